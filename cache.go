@@ -1,120 +1,483 @@
 package library
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
 )
 
 type CacheConfiguration struct {
-	URL      string
-	Password string
-	Prefix   string
-	Port     string
+	URL      string `env:"CACHE_URL"`
+	Username string `env:"CACHE_USERNAME"`
+	Password Secret `env:"CACHE_PASSWORD"`
+	Prefix   string `env:"CACHE_PREFIX"`
+	// Separator joins the prefix, namespaces and key names, "_" when empty.
+	Separator string `env:"CACHE_SEPARATOR"`
+	Port      string `env:"CACHE_PORT" default:"6379" validate:"numeric,min=1,max=65535"`
+	DB        int    `env:"CACHE_DB" validate:"min=0"`
+
+	// LegacyKeys keeps the "_name" form earlier versions wrote for a cache
+	// without a prefix, so their keys stay reachable.
+	LegacyKeys bool `env:"CACHE_LEGACY_KEYS"`
+
+	// Sentinel: the master name and the sentinel host:port addresses.
+	MasterName       string   `env:"CACHE_MASTER_NAME"`
+	SentinelAddrs    []string `env:"CACHE_SENTINEL_ADDRS"`
+	SentinelUsername string   `env:"CACHE_SENTINEL_USERNAME"`
+	SentinelPassword Secret   `env:"CACHE_SENTINEL_PASSWORD"`
+
+	// Cluster: the host:port addresses of the seed nodes.
+	ClusterAddrs []string `env:"CACHE_CLUSTER_ADDRS"`
+
+	TLS                   bool   `env:"CACHE_TLS"`
+	TLSCAFile             string `env:"CACHE_TLS_CA_FILE"`
+	TLSServerName         string `env:"CACHE_TLS_SERVER_NAME"`
+	TLSInsecureSkipVerify bool   `env:"CACHE_TLS_INSECURE_SKIP_VERIFY"`
+
+	PoolSize     int           `env:"CACHE_POOL_SIZE" validate:"min=0"`
+	MinIdleConns int           `env:"CACHE_MIN_IDLE_CONNS" validate:"min=0"`
+	DialTimeout  time.Duration `env:"CACHE_DIAL_TIMEOUT"`
+	ReadTimeout  time.Duration `env:"CACHE_READ_TIMEOUT"`
+	WriteTimeout time.Duration `env:"CACHE_WRITE_TIMEOUT"`
+
+	// DefaultTTL applies to Set calls made with a zero duration. When it is
+	// unset a zero duration stores the key without expiry, as before.
+	DefaultTTL time.Duration `env:"CACHE_DEFAULT_TTL"`
+
+	// Backend is the OpenCache backend: redis, memory, noop, or tiered for a
+	// local store in front of Redis. Empty means redis.
+	Backend string `env:"CACHE_BACKEND" default:"redis"`
+	// LocalSize and LocalTTL bound the in-process store of the memory and
+	// tiered backends, a zero LocalSize is unbounded.
+	LocalSize int           `env:"CACHE_LOCAL_SIZE" default:"10000" validate:"min=0"`
+	LocalTTL  time.Duration `env:"CACHE_LOCAL_TTL" default:"1m"`
 }
 
-func newRedisClient(url, port, password string, dbIndex int) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     url + ":" + port,
-		Password: password,
-		DB:       dbIndex,
-	})
+func (cfg CacheConfiguration) Validate() error {
+	var errs FieldErrors
+	switch cfg.Backend {
+	case "", CacheBackendRedis, CacheBackendTiered:
+		errs = cfg.validateRedis()
+	case CacheBackendMemory, CacheBackendNoop:
+	default:
+		errs = append(errs, FieldError{Field: "Backend", Key: "CACHE_BACKEND",
+			Err: fmt.Errorf("must be one of [redis memory noop tiered], got %q", cfg.Backend)})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
-func NewCache(cfg CacheConfiguration, expiracy int) Cache {
-	return Cache{
-		rdb:    newRedisClient(cfg.URL, cfg.Port, cfg.Password, 0),
-		prefix: cfg.Prefix,
+
+func (cfg CacheConfiguration) validateRedis() FieldErrors {
+	var errs FieldErrors
+	switch {
+	case len(cfg.ClusterAddrs) > 0 && len(cfg.SentinelAddrs) > 0:
+		errs = append(errs, FieldError{Field: "ClusterAddrs", Key: "CACHE_CLUSTER_ADDRS",
+			Err: errors.New("cannot be combined with SentinelAddrs")})
+	case len(cfg.SentinelAddrs) > 0:
+		if cfg.MasterName == "" {
+			errs = append(errs, FieldError{Field: "MasterName", Key: "CACHE_MASTER_NAME",
+				Err: errors.New("is required with SentinelAddrs")})
+		}
+	case len(cfg.ClusterAddrs) == 0:
+		if cfg.URL == "" {
+			errs = append(errs, FieldError{Field: "URL", Key: "CACHE_URL", Err: errors.New("is required")})
+		}
+		if cfg.Port == "" {
+			errs = append(errs, FieldError{Field: "Port", Key: "CACHE_PORT", Err: errors.New("is required")})
+		}
 	}
+	if len(cfg.ClusterAddrs) > 0 && cfg.DB != 0 {
+		errs = append(errs, FieldError{Field: "DB", Key: "CACHE_DB", Err: errors.New("must be 0 in cluster mode")})
+	}
+	return errs
 }
 
-type Cache struct {
-	rdb    *redis.Client
-	prefix string
+func (cfg CacheConfiguration) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file : %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
 }
 
-func (c *Cache) Set(name string, value string, tm time.Duration) error {
-	return c.rdb.Set(ctxB, c.prefix+"_"+name, value, tm).Err()
+// newRedisClient picks a cluster, sentinel or single-node client from cfg.
+func newRedisClient(cfg CacheConfiguration) (redis.UniversalClient, error) {
+	tc, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opt := &redis.UniversalOptions{
+		Username:         cfg.Username,
+		Password:         cfg.Password.Reveal(),
+		DB:               cfg.DB,
+		MasterName:       cfg.MasterName,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword.Reveal(),
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		TLSConfig:        tc,
+	}
+
+	switch {
+	case len(cfg.ClusterAddrs) > 0:
+		opt.Addrs = cfg.ClusterAddrs
+		return redis.NewClusterClient(opt.Cluster()), nil
+	case len(cfg.SentinelAddrs) > 0:
+		opt.Addrs = cfg.SentinelAddrs
+		return redis.NewFailoverClient(opt.Failover()), nil
+	default:
+		opt.Addrs = []string{cfg.URL + ":" + cfg.Port}
+		return redis.NewClient(opt.Simple()), nil
+	}
 }
 
-func (c *Cache) SaveToken(name string, value string, tm time.Duration) error {
-	return c.rdb.Set(ctxB, c.prefix+"_"+name, value, tm).Err()
+// NewCache connects to the topology described by cfg. expiracy is ignored and
+// kept for compatibility; set cfg.DefaultTTL for a default TTL.
+func NewCache(cfg CacheConfiguration, expiracy int) (*RedisCache, error) {
+	if err := ValidateStruct(cfg); err != nil {
+		return nil, fmt.Errorf("invalid cache configuration : %w", err)
+	}
+
+	rdb, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init cache client : %w", err)
+	}
+
+	return &RedisCache{
+		rdb:        rdb,
+		prefix:     cfg.Prefix,
+		separator:  cfg.Separator,
+		legacyKeys: cfg.LegacyKeys,
+		defaultTTL: cfg.DefaultTTL,
+	}, nil
 }
 
-func (c *Cache) Get(name string) (string, error) {
-	return c.rdb.Get(ctxB, c.prefix+"_"+name).Result()
+const (
+	defaultSeparator = "_"
+	scanCount        = 100
+)
+
+// errNoPrefix refuses ClearKeys on a cache without a prefix, whose keys
+// cannot be told apart from anything else in the store.
+var errNoPrefix = errors.New("clear keys : cache has no prefix")
+
+// NoExpiry stores a key without expiry even when a default TTL is set.
+const NoExpiry time.Duration = -1
+
+// RedisCache is the Redis backend of Cache. Locks, rate limiters, sessions,
+// queues, pub/sub, streams and the data structure commands need it directly.
+type RedisCache struct {
+	rdb        redis.UniversalClient
+	prefix     string
+	separator  string
+	legacyKeys bool
+	defaultTTL time.Duration
+
+	metrics       CacheMetrics
+	slowLog       *zap.Logger
+	slowThreshold time.Duration
+
+	// onClose stops the embedded server of LocalCache
+	onClose func()
 }
 
-func (c *Cache) Delete(name string) error {
-	return c.rdb.Del(ctxB, c.prefix+"_"+name).Err()
+func (c *RedisCache) sep() string {
+	if c.separator == "" {
+		return defaultSeparator
+	}
+	return c.separator
 }
 
-// use this when init for ServiceContext, for local test
-func MockCache(t *testing.T) Cache {
-	mr, err := miniredis.Run()
-	if err != nil {
-		t.Fatalf("fail init mock cache: %s", err)
+func (c *RedisCache) key(name string) string {
+	return c.keyPrefix() + name
+}
+
+// keyPrefix is what key puts before every name: nothing without a prefix,
+// unless LegacyKeys asks for the "_name" form.
+func (c *RedisCache) keyPrefix() string {
+	if c.prefix == "" && !c.legacyKeys {
+		return ""
 	}
+	return c.prefix + c.sep()
+}
 
-	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	_, err = miniredis.Run()
-	if err != nil {
-		t.Fatalf("fail run mock cache: %s", err)
+func joinKey(prefix, separator, name string) string {
+	if prefix == "" {
+		return name
+	}
+	if separator == "" {
+		separator = defaultSeparator
+	}
+	return prefix + separator + name
+}
+
+// Namespace returns a cache whose keys live under name inside this one, for
+// example cache.Namespace("users") stores "id" as "<prefix>_users_id". Locks,
+// sessions, queues and streams work on it like on the root cache.
+func (c *RedisCache) Namespace(name string) *RedisCache {
+	child := *c
+	child.prefix = joinKey(c.prefix, c.separator, name)
+	return &child
+}
+
+func (c *RedisCache) WithNamespace(name string) Cache {
+	return c.Namespace(name)
+}
+
+// Prefix is the full key prefix of this cache, without the trailing separator.
+func (c *RedisCache) Prefix() string {
+	return c.prefix
+}
+
+func (c *RedisCache) Set(name string, value string, tm time.Duration) error {
+	return c.SetCtx(ctxB, name, value, tm)
+}
+
+func (c *RedisCache) ttl(tm time.Duration) time.Duration {
+	switch tm {
+	case 0:
+		return c.defaultTTL
+	case NoExpiry:
+		return 0
 	}
+	return tm
+}
+
+// SetCtx stores value for tm, or for the default TTL when tm is zero. Without
+// a default TTL, and with NoExpiry, the key does not expire.
+func (c *RedisCache) SetCtx(ctx context.Context, name string, value string, tm time.Duration) error {
+	start := time.Now()
+	err := c.rdb.Set(ctx, c.key(name), value, c.ttl(tm)).Err()
+	c.observe("set", name, start, err)
+	return err
+}
+
+// Deprecated: SaveToken is Set under another name, use SessionStore for tokens.
+func (c *RedisCache) SaveToken(name string, value string, tm time.Duration) error {
+	return c.SetCtx(ctxB, name, value, tm)
+}
 
-	return Cache{
-		rdb:    client,
-		prefix: "",
+func (c *RedisCache) Get(name string) (string, error) {
+	return c.GetCtx(ctxB, name)
+}
+
+// GetCtx returns ErrCacheMiss when name is not cached.
+func (c *RedisCache) GetCtx(ctx context.Context, name string) (string, error) {
+	start := time.Now()
+	val, err := c.rdb.Get(ctx, c.key(name)).Result()
+	return val, c.got(name, start, err)
+}
+
+// getWithTTL is GetCtx also returning the remaining TTL, -1 for a key without
+// expiry, read in the same round trip.
+func (c *RedisCache) getWithTTL(ctx context.Context, name string) (string, time.Duration, error) {
+	start := time.Now()
+	pipe := c.rdb.Pipeline()
+	get := pipe.Get(ctx, c.key(name))
+	pttl := pipe.PTTL(ctx, c.key(name))
+	pipe.Exec(ctx)
+
+	val, err := get.Result()
+	if err == nil {
+		err = pttl.Err()
 	}
+	return val, pttl.Val(), c.got(name, start, err)
 }
-func LocalCache() (Cache, error) {
-	mr, err := miniredis.Run()
-	if err != nil {
-		return Cache{}, fmt.Errorf("fail init local cache: %s", err)
+
+// got instruments a GET, turning redis.Nil into ErrCacheMiss.
+func (c *RedisCache) got(name string, start time.Time, err error) error {
+	if err == redis.Nil {
+		err = ErrCacheMiss
+	}
+	c.observe("get", name, start, err)
+	if c.metrics != nil {
+		switch err {
+		case nil:
+			c.metrics.Hit(c.prefix)
+		case ErrCacheMiss:
+			c.metrics.Miss(c.prefix)
+		}
 	}
+	return err
+}
+
+func (c *RedisCache) Delete(name string) error {
+	return c.DeleteCtx(ctxB, name)
+}
 
-	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	_, err = miniredis.Run()
+func (c *RedisCache) DeleteCtx(ctx context.Context, name string) error {
+	start := time.Now()
+	err := c.rdb.Del(ctx, c.key(name)).Err()
+	c.observe("del", name, start, err)
+	return err
+}
+
+// MockCache is a Cache on a miniredis server that stops when the test ends.
+// Use NewCacheFixture to control time or inspect keys.
+func MockCache(t *testing.T) *RedisCache {
+	return NewCacheFixture(t).RedisCache
+}
+
+// LocalCache runs an in-process miniredis for local development. Close the
+// returned cache to stop the server.
+func LocalCache() (*RedisCache, error) {
+	mr, err := miniredis.Run()
 	if err != nil {
-		return Cache{}, fmt.Errorf("fail  run local cache: %s", err)
+		return nil, fmt.Errorf("fail init local cache: %s", err)
 	}
 
-	return Cache{
-		rdb:    client,
-		prefix: "",
+	return &RedisCache{
+		rdb:     redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		prefix:  "",
+		onClose: mr.Close,
 	}, nil
 }
-func (c *Cache) GetKeys() []string {
-	var cursor uint64
+
+// Close closes the connection pool, shared by every Namespace of the cache.
+func (c *RedisCache) Close() error {
+	err := c.rdb.Close()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return err
+}
+
+// GetKeys lists the keys of this cache's namespace, without the prefix.
+func (c *RedisCache) GetKeys() ([]string, error) {
+	return c.GetKeysCtx(ctxB)
+}
+
+func (c *RedisCache) GetKeysCtx(ctx context.Context) ([]string, error) {
+	strip := len(c.keyPrefix())
+
+	start := time.Now()
 	var result []string
+	err := c.scan(ctx, func(keys []string) error {
+		for _, k := range keys {
+			result = append(result, k[strip:])
+		}
+		return nil
+	})
+	c.observe("scan", "", start, err)
+	if err == nil && c.metrics != nil {
+		c.metrics.Keys(c.prefix, len(result))
+	}
+	return result, err
+}
+
+// scan walks the keys under the prefix in batches, with SCAN MATCH so other
+// prefixes sharing the database are never touched. fn is never called
+// concurrently, not even while the masters of a cluster are scanned in
+// parallel.
+func (c *RedisCache) scan(ctx context.Context, fn func(keys []string) error) error {
+	pattern := escapeGlob(c.keyPrefix()) + "*"
+
+	if cc, ok := c.rdb.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		locked := func(keys []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(keys)
+		}
+		return cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, pattern, locked)
+		})
+	}
+	return scanNode(ctx, c.rdb, pattern, fn)
+}
+
+func scanNode(ctx context.Context, rdb redis.Cmdable, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
 	for {
-		var keys []string
-		var err error
-		keys, cursor, err = c.rdb.Scan(ctxB, cursor, "", 0).Result()
+		keys, next, err := rdb.Scan(ctx, cursor, pattern, scanCount).Result()
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("scan %s : %w", pattern, err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
 		}
-		result = append(result, keys...)
+		cursor = next
 		if cursor == 0 { // no more keys
-			break
+			return nil
 		}
 	}
+}
 
-	return result
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
 }
 
-func (c *Cache) ClearKeys() {
-	keys := c.GetKeys()
-	pipe := c.rdb.Pipeline()
-	for _, key := range keys {
-		pipe.Del(ctxB, key)
+// ClearKeys unlinks every key of this cache's namespace. It refuses to run
+// on a cache without a prefix, whose namespace is shared with anything else
+// in the database.
+func (c *RedisCache) ClearKeys() error {
+	return c.ClearKeysCtx(ctxB)
+}
+
+func (c *RedisCache) ClearKeysCtx(ctx context.Context) error {
+	if c.keyPrefix() == "" {
+		return errNoPrefix
 	}
-	pipe.Exec(ctxB)
+	start := time.Now()
+	err := c.scan(ctx, func(keys []string) error {
+		// one UNLINK per key so cluster pipelines can route them by slot
+		pipe := c.rdb.Pipeline()
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("unlink keys : %w", err)
+		}
+		return nil
+	})
+	c.observe("unlink", "", start, err)
+	if err == nil && c.metrics != nil {
+		c.metrics.Keys(c.prefix, 0)
+	}
+	return err
+}
+
+func (c *RedisCache) Ping() bool {
+	return c.PingCtx(ctxB)
 }
 
-func (c *Cache) Ping() bool {
-	_, err := c.rdb.Ping(ctxB).Result()
+func (c *RedisCache) PingCtx(ctx context.Context) bool {
+	_, err := c.rdb.Ping(ctx).Result()
 	return err == nil
 }
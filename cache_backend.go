@@ -0,0 +1,165 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	CacheBackendRedis  = "redis"
+	CacheBackendMemory = "memory"
+	CacheBackendNoop   = "noop"
+	CacheBackendTiered = "tiered"
+)
+
+// Cache is the key/value API every backend implements: RedisCache,
+// MemoryCache, NoopCache and TieredCache. Names are relative to the cache
+// namespace and a zero TTL means the backend's default TTL. Get returns
+// ErrCacheMiss for a missing name. Every backend lays keys out the same way:
+// a namespace joins its name with the separator, GetKeys lists names Get
+// accepts, and ClearKeys refuses to run on a cache without a prefix.
+type Cache interface {
+	Get(name string) (string, error)
+	GetCtx(ctx context.Context, name string) (string, error)
+	Set(name string, value string, tm time.Duration) error
+	SetCtx(ctx context.Context, name string, value string, tm time.Duration) error
+	Delete(name string) error
+	DeleteCtx(ctx context.Context, name string) error
+	GetKeys() ([]string, error)
+	GetKeysCtx(ctx context.Context) ([]string, error)
+	ClearKeys() error
+	ClearKeysCtx(ctx context.Context) error
+	// WithNamespace is Namespace for code holding a Cache; the backends'
+	// own Namespace returns their concrete type.
+	WithNamespace(name string) Cache
+	Prefix() string
+	Ping() bool
+	PingCtx(ctx context.Context) bool
+	Close() error
+}
+
+// OpenCache builds the backend selected by cfg.Backend. A tiered cache is
+// already subscribed to invalidations, Close stops it.
+func OpenCache(cfg CacheConfiguration, log *zap.Logger) (Cache, error) {
+	if err := ValidateStruct(cfg); err != nil {
+		return nil, fmt.Errorf("invalid cache configuration : %w", err)
+	}
+
+	switch cfg.Backend {
+	case CacheBackendMemory:
+		c := NewMemoryCache(cfg.LocalSize, cfg.DefaultTTL)
+		c.prefix, c.separator = cfg.Prefix, cfg.Separator
+		return c, nil
+	case CacheBackendNoop:
+		return NoopCache{prefix: cfg.Prefix, separator: cfg.Separator}, nil
+	case CacheBackendTiered:
+		rc, err := NewCache(cfg, 0)
+		if err != nil {
+			return nil, err
+		}
+		tc, err := NewTieredCache(rc, NewLocalStore(cfg.LocalSize, EvictLRU), cfg.LocalTTL, log)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		if err := tc.Start(ctxB); err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return tc, nil
+	default:
+		rc, err := NewCache(cfg, 0)
+		if err != nil {
+			return nil, err
+		}
+		return rc, nil
+	}
+}
+
+// redisBackend returns the Redis cache behind c, or nil when there is none.
+func redisBackend(c Cache) *RedisCache {
+	switch c := c.(type) {
+	case *RedisCache:
+		return c
+	case *TieredCache:
+		return c.redis
+	}
+	return nil
+}
+
+// NoopCache stores nothing: every Get misses and every write succeeds. Use
+// it to switch caching off without touching the callers.
+type NoopCache struct {
+	prefix    string
+	separator string
+}
+
+func (c NoopCache) Namespace(name string) NoopCache {
+	return NoopCache{prefix: joinKey(c.prefix, c.separator, name), separator: c.separator}
+}
+
+func (c NoopCache) WithNamespace(name string) Cache {
+	return c.Namespace(name)
+}
+
+func (c NoopCache) Prefix() string {
+	return c.prefix
+}
+
+func (c NoopCache) Get(name string) (string, error) {
+	return "", ErrCacheMiss
+}
+
+func (c NoopCache) GetCtx(ctx context.Context, name string) (string, error) {
+	return "", ErrCacheMiss
+}
+
+func (c NoopCache) Set(name string, value string, tm time.Duration) error {
+	return nil
+}
+
+func (c NoopCache) SetCtx(ctx context.Context, name string, value string, tm time.Duration) error {
+	return nil
+}
+
+func (c NoopCache) Delete(name string) error {
+	return nil
+}
+
+func (c NoopCache) DeleteCtx(ctx context.Context, name string) error {
+	return nil
+}
+
+func (c NoopCache) GetKeys() ([]string, error) {
+	return nil, nil
+}
+
+func (c NoopCache) GetKeysCtx(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (c NoopCache) ClearKeys() error {
+	return c.ClearKeysCtx(ctxB)
+}
+
+func (c NoopCache) ClearKeysCtx(ctx context.Context) error {
+	if c.prefix == "" {
+		return errNoPrefix
+	}
+	return nil
+}
+
+func (c NoopCache) Ping() bool {
+	return true
+}
+
+func (c NoopCache) PingCtx(ctx context.Context) bool {
+	return true
+}
+
+func (c NoopCache) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package library
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCacheBackends(t *testing.T) {
+	// every backend is built with the same separator, their keys must agree
+	backends := map[string]func(t *testing.T) Cache{
+		"redis": func(t *testing.T) Cache {
+			c := MockCache(t)
+			c.separator = ":"
+			return c
+		},
+		"memory": func(t *testing.T) Cache {
+			c := NewMemoryCache(0, 0)
+			c.separator = ":"
+			return c
+		},
+		"noop": func(t *testing.T) Cache { return NoopCache{separator: ":"} },
+		"tiered": func(t *testing.T) Cache {
+			mc := NewMemoryCache(0, 0)
+			mc.separator = ":"
+			tc, err := NewTieredCache(mc, NewLocalStore(10, EvictLRU), time.Minute, zap.NewNop())
+			assert.NoError(t, err)
+			return tc
+		},
+		"tiered-redis": func(t *testing.T) Cache {
+			rc := MockCache(t)
+			rc.separator = ":"
+			tc, err := NewTieredCache(rc, NewLocalStore(10, EvictLRU), time.Minute, zap.NewNop())
+			assert.NoError(t, err)
+			return tc
+		},
+	}
+
+	for name, newCache := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newCache(t)
+			users := c.WithNamespace("users")
+			assert.Equal(t, "users", users.Prefix())
+			assert.Equal(t, "users:admins", users.WithNamespace("admins").Prefix())
+
+			// a cache without a prefix is never cleared, a namespace is
+			assert.Error(t, c.ClearKeys())
+			assert.NoError(t, users.ClearKeys())
+			if name == "noop" {
+				return
+			}
+
+			assert.NoError(t, c.Set("a", "1", time.Minute))
+			assert.NoError(t, users.Set("a", "2", time.Minute))
+			assert.NoError(t, users.Set("b", "3", time.Minute))
+
+			val, err := c.Get("a")
+			assert.NoError(t, err)
+			assert.Equal(t, "1", val)
+			val, err = users.GetCtx(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, "2", val)
+			_, err = users.Get("missing")
+			assert.ErrorIs(t, err, ErrCacheMiss)
+
+			keys, err := users.GetKeys()
+			assert.NoError(t, err)
+			sort.Strings(keys)
+			assert.Equal(t, []string{"a", "b"}, keys)
+
+			// the root lists the namespaced keys under names it can read
+			keys, err = c.GetKeys()
+			assert.NoError(t, err)
+			sort.Strings(keys)
+			assert.Equal(t, []string{"a", "users:a", "users:b"}, keys)
+			for _, k := range keys {
+				_, err := c.Get(k)
+				assert.NoError(t, err, k)
+			}
+
+			assert.NoError(t, users.Delete("a"))
+			_, err = users.Get("a")
+			assert.ErrorIs(t, err, ErrCacheMiss)
+
+			assert.NoError(t, users.ClearKeys())
+			_, err = users.Get("b")
+			assert.ErrorIs(t, err, ErrCacheMiss)
+			val, _ = c.Get("a")
+			assert.Equal(t, "1", val)
+
+			assert.True(t, c.Ping())
+			assert.NoError(t, c.Close())
+		})
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	c := NewMemoryCache(0, 20*time.Millisecond)
+	assert.NoError(t, c.Set("short", "v", 0))
+	assert.NoError(t, c.Set("long", "v", time.Minute))
+
+	time.Sleep(30 * time.Millisecond)
+	_, err := c.Get("short")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	keys, _ := c.GetKeys()
+	assert.Equal(t, []string{"long"}, keys)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetCtx(canceled, "long")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNoopCache(t *testing.T) {
+	var c Cache = NoopCache{}
+	assert.NoError(t, c.Set("a", "1", time.Minute))
+	_, err := c.Get("a")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, "x_y", c.WithNamespace("x").WithNamespace("y").Prefix())
+
+	// the loader runs every time, nothing is kept
+	calls := 0
+	tc := NewTypedCache[int](c, nil)
+	for i := 0; i < 2; i++ {
+		v, err := tc.GetOrLoad("n", time.Minute, func() (int, error) {
+			calls++
+			return 7, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 7, v)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestOpenCache(t *testing.T) {
+	open := func(cfg CacheConfiguration) (Cache, error) {
+		c, err := OpenCache(cfg, zap.NewNop())
+		if err == nil {
+			t.Cleanup(func() { c.Close() })
+		}
+		return c, err
+	}
+
+	c, err := open(CacheConfiguration{Backend: CacheBackendMemory, Prefix: "svc"})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryCache{}, c)
+	assert.Equal(t, "svc_users", c.WithNamespace("users").Prefix())
+
+	c, err = open(CacheConfiguration{Backend: CacheBackendNoop})
+	assert.NoError(t, err)
+	assert.IsType(t, NoopCache{}, c)
+
+	mr := miniredis.RunT(t)
+	host, port, _ := strings.Cut(mr.Addr(), ":")
+	c, err = open(CacheConfiguration{URL: host, Port: port})
+	assert.NoError(t, err)
+	assert.IsType(t, &RedisCache{}, c)
+
+	c, err = open(CacheConfiguration{Backend: CacheBackendTiered, URL: host, Port: port, LocalSize: 10, LocalTTL: time.Minute})
+	assert.NoError(t, err)
+	assert.IsType(t, &TieredCache{}, c)
+	assert.NotNil(t, redisBackend(c))
+	assert.NoError(t, c.Set("k", "v", time.Minute))
+	assert.True(t, mr.Exists("k"))
+	assert.NoError(t, c.Close())
+
+	_, err = open(CacheConfiguration{Backend: "memcached"})
+	assert.ErrorContains(t, err, "Backend")
+	_, err = open(CacheConfiguration{Backend: CacheBackendRedis})
+	assert.ErrorContains(t, err, "URL")
+}
@@ -0,0 +1,352 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// incrScript adds to a counter and sets the TTL only when the counter has
+// none, so the window starts at the first increment.
+var incrScript = redis.NewScript(`
+local v = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return v
+`)
+
+// track times a command for the metrics and slow log; call the returned
+// func with the command's error.
+func (c *RedisCache) track(command, name string) func(error) error {
+	start := time.Now()
+	return func(err error) error {
+		c.observe(command, name, start, err)
+		return err
+	}
+}
+
+// Incr adds one to the counter name. A new counter expires after ttl, or the
+// default TTL when ttl is zero; later increments keep that expiry.
+func (c *RedisCache) Incr(ctx context.Context, name string, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, name, 1, ttl)
+}
+
+func (c *RedisCache) IncrBy(ctx context.Context, name string, delta int64, ttl time.Duration) (int64, error) {
+	done := c.track("incrby", name)
+	n, err := incrScript.Run(ctx, c.rdb, []string{c.key(name)}, delta, c.ttl(ttl).Milliseconds()).Int64()
+	return n, done(err)
+}
+
+func (c *RedisCache) Decr(ctx context.Context, name string, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, name, -1, ttl)
+}
+
+func (c *RedisCache) DecrBy(ctx context.Context, name string, delta int64, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, name, -delta, ttl)
+}
+
+// Expire sets the TTL of name, whatever its type, to ttl or the default TTL
+// when ttl is zero. NoExpiry removes the expiry; any other ttl that is not
+// positive is rejected, since EXPIRE would delete the key.
+func (c *RedisCache) Expire(ctx context.Context, name string, ttl time.Duration) error {
+	done := c.track("expire", name)
+	if ttl == NoExpiry {
+		return done(c.rdb.Persist(ctx, c.key(name)).Err())
+	}
+	tm := c.ttl(ttl)
+	if tm <= 0 {
+		return done(fmt.Errorf("expire %s : ttl must be positive, got %s", name, tm))
+	}
+	return done(c.rdb.Expire(ctx, c.key(name), tm).Err())
+}
+
+// TTL returns ErrCacheMiss when name does not exist and -1 when it has no
+// expiry.
+func (c *RedisCache) TTL(ctx context.Context, name string) (time.Duration, error) {
+	done := c.track("pttl", name)
+	d, err := c.rdb.PTTL(ctx, c.key(name)).Result()
+	if err == nil && d == -2 {
+		err = ErrCacheMiss
+	}
+	return d, done(err)
+}
+
+// MGet returns the values of the names that exist. It pipelines one GET per
+// name, so it also works across cluster slots.
+func (c *RedisCache) MGet(ctx context.Context, names ...string) (map[string]string, error) {
+	done := c.track("mget", "")
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(names))
+	for i, name := range names {
+		cmds[i] = pipe.Get(ctx, c.key(name))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, done(fmt.Errorf("mget : %w", err))
+	}
+
+	values := make(map[string]string, len(names))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, done(fmt.Errorf("mget %s : %w", names[i], err))
+		}
+		values[names[i]] = val
+	}
+	return values, done(nil)
+}
+
+// MSet stores every value for ttl, or the default TTL when ttl is zero.
+func (c *RedisCache) MSet(ctx context.Context, values map[string]string, ttl time.Duration) error {
+	done := c.track("mset", "")
+	pipe := c.rdb.Pipeline()
+	for name, val := range values {
+		pipe.Set(ctx, c.key(name), val, c.ttl(ttl))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return done(fmt.Errorf("mset : %w", err))
+	}
+	return done(nil)
+}
+
+// HSet writes the fields of v into the hash name. v is a map or a struct whose
+// fields carry `redis:"field"` tags, the same tags HGetAll reads. A non-zero
+// ttl, or the default TTL, is applied to the whole hash.
+func (c *RedisCache) HSet(ctx context.Context, name string, v interface{}, ttl time.Duration) error {
+	done := c.track("hset", name)
+	fields, err := hashFields(v)
+	if err != nil {
+		return done(fmt.Errorf("hset %s : %w", name, err))
+	}
+
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, c.key(name), fields)
+	if tm := c.ttl(ttl); tm > 0 {
+		pipe.Expire(ctx, c.key(name), tm)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return done(fmt.Errorf("hset %s : %w", name, err))
+	}
+	return done(nil)
+}
+
+// HGet returns ErrCacheMiss when the hash or the field does not exist.
+func (c *RedisCache) HGet(ctx context.Context, name, field string) (string, error) {
+	done := c.track("hget", name)
+	val, err := c.rdb.HGet(ctx, c.key(name), field).Result()
+	if err == redis.Nil {
+		err = ErrCacheMiss
+	}
+	return val, done(err)
+}
+
+// HGetAll reads the hash name into dst, a pointer to a struct with
+// `redis:"field"` tags. It returns ErrCacheMiss when the hash does not exist.
+func (c *RedisCache) HGetAll(ctx context.Context, name string, dst interface{}) error {
+	done := c.track("hgetall", name)
+	cmd := c.rdb.HGetAll(ctx, c.key(name))
+	vals, err := cmd.Result()
+	if err != nil {
+		return done(err)
+	}
+	if len(vals) == 0 {
+		return done(ErrCacheMiss)
+	}
+	if err := cmd.Scan(dst); err != nil {
+		return done(fmt.Errorf("hgetall %s : %w", name, err))
+	}
+	return done(nil)
+}
+
+func (c *RedisCache) HDel(ctx context.Context, name string, fields ...string) error {
+	done := c.track("hdel", name)
+	return done(c.rdb.HDel(ctx, c.key(name), fields...).Err())
+}
+
+func (c *RedisCache) HIncrBy(ctx context.Context, name, field string, delta int64) (int64, error) {
+	done := c.track("hincrby", name)
+	n, err := c.rdb.HIncrBy(ctx, c.key(name), field, delta).Result()
+	return n, done(err)
+}
+
+func hashFields(v interface{}) (map[string]interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	fields := map[string]interface{}{}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key must be a string, got %s", rv.Type().Key())
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			fields[iter.Key().String()] = iter.Value().Interface()
+		}
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			tag := strings.Split(rt.Field(i).Tag.Get("redis"), ",")[0]
+			if tag == "" || tag == "-" || !rt.Field(i).IsExported() {
+				continue
+			}
+			fields[tag] = rv.Field(i).Interface()
+		}
+	default:
+		return nil, fmt.Errorf("expected a map or a struct, got %T", v)
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("no fields to set")
+	}
+	return fields, nil
+}
+
+func (c *RedisCache) SAdd(ctx context.Context, name string, members ...string) error {
+	done := c.track("sadd", name)
+	return done(c.rdb.SAdd(ctx, c.key(name), stringArgs(members)...).Err())
+}
+
+func (c *RedisCache) SRem(ctx context.Context, name string, members ...string) error {
+	done := c.track("srem", name)
+	return done(c.rdb.SRem(ctx, c.key(name), stringArgs(members)...).Err())
+}
+
+func (c *RedisCache) SMembers(ctx context.Context, name string) ([]string, error) {
+	done := c.track("smembers", name)
+	members, err := c.rdb.SMembers(ctx, c.key(name)).Result()
+	return members, done(err)
+}
+
+func (c *RedisCache) SIsMember(ctx context.Context, name, member string) (bool, error) {
+	done := c.track("sismember", name)
+	ok, err := c.rdb.SIsMember(ctx, c.key(name), member).Result()
+	return ok, done(err)
+}
+
+func (c *RedisCache) SCard(ctx context.Context, name string) (int64, error) {
+	done := c.track("scard", name)
+	n, err := c.rdb.SCard(ctx, c.key(name)).Result()
+	return n, done(err)
+}
+
+func stringArgs(ss []string) []interface{} {
+	args := make([]interface{}, len(ss))
+	for i, s := range ss {
+		args[i] = s
+	}
+	return args
+}
+
+// ZMember is a sorted set member with its score, for example a player and
+// their points or an id and its unix timestamp.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+func (c *RedisCache) ZAdd(ctx context.Context, name string, members ...ZMember) error {
+	done := c.track("zadd", name)
+	zs := make([]*redis.Z, len(members))
+	for i, m := range members {
+		zs[i] = &redis.Z{Member: m.Member, Score: m.Score}
+	}
+	return done(c.rdb.ZAdd(ctx, c.key(name), zs...).Err())
+}
+
+func (c *RedisCache) ZIncrBy(ctx context.Context, name, member string, delta float64) (float64, error) {
+	done := c.track("zincrby", name)
+	score, err := c.rdb.ZIncrBy(ctx, c.key(name), delta, member).Result()
+	return score, done(err)
+}
+
+func (c *RedisCache) ZRem(ctx context.Context, name string, members ...string) error {
+	done := c.track("zrem", name)
+	return done(c.rdb.ZRem(ctx, c.key(name), stringArgs(members)...).Err())
+}
+
+// ZScore returns ErrCacheMiss when member is not in the set.
+func (c *RedisCache) ZScore(ctx context.Context, name, member string) (float64, error) {
+	done := c.track("zscore", name)
+	score, err := c.rdb.ZScore(ctx, c.key(name), member).Result()
+	if err == redis.Nil {
+		err = ErrCacheMiss
+	}
+	return score, done(err)
+}
+
+// ZRevRank is the 0-based leaderboard position of member, highest score
+// first. It returns ErrCacheMiss when member is not in the set.
+func (c *RedisCache) ZRevRank(ctx context.Context, name, member string) (int64, error) {
+	done := c.track("zrevrank", name)
+	rank, err := c.rdb.ZRevRank(ctx, c.key(name), member).Result()
+	if err == redis.Nil {
+		err = ErrCacheMiss
+	}
+	return rank, done(err)
+}
+
+// ZTop returns the n members with the highest scores, highest first, and
+// none when n is not positive.
+func (c *RedisCache) ZTop(ctx context.Context, name string, n int64) ([]ZMember, error) {
+	if n <= 0 {
+		return []ZMember{}, nil
+	}
+	done := c.track("zrevrange", name)
+	zs, err := c.rdb.ZRevRangeWithScores(ctx, c.key(name), 0, n-1).Result()
+	return zMembers(zs), done(err)
+}
+
+// ZRangeByScore returns members scored between min and max inclusive, lowest
+// first, at most limit of them when limit is positive. With unix timestamps as
+// scores this reads a time window of an index.
+func (c *RedisCache) ZRangeByScore(ctx context.Context, name string, min, max float64, limit int64) ([]ZMember, error) {
+	done := c.track("zrangebyscore", name)
+	opt := &redis.ZRangeBy{Min: formatScore(min), Max: formatScore(max)}
+	if limit > 0 {
+		opt.Count = limit
+	}
+	zs, err := c.rdb.ZRangeByScoreWithScores(ctx, c.key(name), opt).Result()
+	return zMembers(zs), done(err)
+}
+
+// ZRemRangeByScore drops members scored between min and max inclusive, for
+// example index entries older than a cutoff, and returns how many it removed.
+func (c *RedisCache) ZRemRangeByScore(ctx context.Context, name string, min, max float64) (int64, error) {
+	done := c.track("zremrangebyscore", name)
+	n, err := c.rdb.ZRemRangeByScore(ctx, c.key(name), formatScore(min), formatScore(max)).Result()
+	return n, done(err)
+}
+
+func (c *RedisCache) ZCard(ctx context.Context, name string) (int64, error) {
+	done := c.track("zcard", name)
+	n, err := c.rdb.ZCard(ctx, c.key(name)).Result()
+	return n, done(err)
+}
+
+func zMembers(zs []redis.Z) []ZMember {
+	members := make([]ZMember, len(zs))
+	for i, z := range zs {
+		members[i] = ZMember{Member: fmt.Sprint(z.Member), Score: z.Score}
+	}
+	return members
+}
+
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
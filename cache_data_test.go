@@ -0,0 +1,163 @@
+package library
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheCounters(t *testing.T) {
+	ctx := context.Background()
+	root := MockCache(t)
+	c := root.Namespace("hits")
+
+	n, err := c.Incr(ctx, "home", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = c.IncrBy(ctx, "home", 5, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), n)
+	n, err = c.DecrBy(ctx, "home", 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+
+	// the first increment sets the expiry
+	ttl, err := c.TTL(ctx, "home")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	_, err = c.TTL(ctx, "missing")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	val, err := c.Get("home")
+	assert.NoError(t, err)
+	assert.Equal(t, "4", val)
+}
+
+func TestCacheMGetMSet(t *testing.T) {
+	ctx := context.Background()
+	root := MockCache(t)
+	c := root.Namespace("m")
+
+	assert.NoError(t, c.MSet(ctx, map[string]string{"a": "1", "b": "2"}, time.Minute))
+	vals, err := c.MGet(ctx, "a", "b", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, vals)
+
+	// only missing keys are skipped, other errors are returned
+	assert.NoError(t, c.SAdd(ctx, "set", "x"))
+	_, err = c.MGet(ctx, "a", "set")
+	assert.ErrorContains(t, err, "WRONGTYPE")
+}
+
+func TestCacheExpire(t *testing.T) {
+	ctx := context.Background()
+	f := NewCacheFixture(t)
+
+	assert.NoError(t, f.Set("k", "v", time.Minute))
+	assert.NoError(t, f.Expire(ctx, "k", time.Hour))
+	f.AssertTTL("k", time.Hour)
+
+	// without a default TTL, zero would have deleted the key
+	assert.Error(t, f.Expire(ctx, "k", 0))
+	assert.Error(t, f.Expire(ctx, "k", -time.Second))
+	f.AssertTTL("k", time.Hour)
+
+	assert.NoError(t, f.Expire(ctx, "k", NoExpiry))
+	f.AssertTTL("k", 0)
+}
+
+type profile struct {
+	Name   string  `redis:"name"`
+	Age    int     `redis:"age"`
+	Score  float64 `redis:"score"`
+	Active bool    `redis:"active"`
+	Note   string
+}
+
+func TestCacheHashes(t *testing.T) {
+	ctx := context.Background()
+	root := MockCache(t)
+	c := root.Namespace("profiles")
+
+	in := profile{Name: "ann", Age: 30, Score: 9.5, Active: true, Note: "not stored"}
+	assert.NoError(t, c.HSet(ctx, "1", in, time.Minute))
+	assert.NoError(t, c.HSet(ctx, "1", map[string]interface{}{"age": 31}, 0))
+
+	var out profile
+	assert.NoError(t, c.HGetAll(ctx, "1", &out))
+	assert.Equal(t, profile{Name: "ann", Age: 31, Score: 9.5, Active: true}, out)
+
+	n, err := c.HIncrBy(ctx, "1", "age", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(32), n)
+	name, err := c.HGet(ctx, "1", "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "ann", name)
+
+	assert.NoError(t, c.HDel(ctx, "1", "name"))
+	_, err = c.HGet(ctx, "1", "name")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.ErrorIs(t, c.HGetAll(ctx, "2", &out), ErrCacheMiss)
+	assert.Error(t, c.HSet(ctx, "3", "scalar", 0))
+}
+
+func TestCacheSets(t *testing.T) {
+	ctx := context.Background()
+	c := MockCache(t)
+
+	assert.NoError(t, c.SAdd(ctx, "tags", "go", "redis", "go"))
+	n, err := c.SCard(ctx, "tags")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	ok, err := c.SIsMember(ctx, "tags", "redis")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, c.SRem(ctx, "tags", "redis"))
+	members, err := c.SMembers(ctx, "tags")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go"}, members)
+}
+
+func TestCacheSortedSets(t *testing.T) {
+	ctx := context.Background()
+	root := MockCache(t)
+	c := root.Namespace("game")
+
+	assert.NoError(t, c.ZAdd(ctx, "board", ZMember{"ann", 10}, ZMember{"bob", 30}, ZMember{"cid", 20}))
+	score, err := c.ZIncrBy(ctx, "board", "ann", 25)
+	assert.NoError(t, err)
+	assert.Equal(t, 35.0, score)
+
+	top, err := c.ZTop(ctx, "board", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"ann", 35}, {"bob", 30}}, top)
+	top, err = c.ZTop(ctx, "board", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+	rank, err := c.ZRevRank(ctx, "board", "cid")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rank)
+	_, err = c.ZRevRank(ctx, "board", "dan")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	// time-ordered index
+	assert.NoError(t, c.ZAdd(ctx, "events", ZMember{"e1", 1700000000}, ZMember{"e2", 1700000060}, ZMember{"e3", 1700000120}))
+	window, err := c.ZRangeByScore(ctx, "events", 1700000060, math.Inf(1), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"e2", 1700000060}, {"e3", 1700000120}}, window)
+
+	removed, err := c.ZRemRangeByScore(ctx, "events", math.Inf(-1), 1700000060)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+	n, err := c.ZCard(ctx, "events")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	assert.NoError(t, c.ZRem(ctx, "board", "ann"))
+	_, err = c.ZScore(ctx, "board", "ann")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
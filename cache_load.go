@@ -0,0 +1,172 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const loadLockPoll = 50 * time.Millisecond
+
+type loadCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// loadGroup runs at most one load per key at a time; concurrent callers for
+// the same key wait for and share its result.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+// do starts fn for key unless it is already running and waits for its result
+// until ctx is done. fn runs on its own, so a caller giving up neither stops
+// it nor fails the other callers.
+func (g *loadGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*loadCall{}
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &loadCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.val, call.err
+	}
+}
+
+func (g *loadGroup) run(key string, call *loadCall, fn func() (interface{}, error)) {
+	// a panicking loader must not leave the waiters blocked forever
+	defer func() {
+		if r := recover(); r != nil {
+			call.val, call.err = nil, fmt.Errorf("load %s : panic : %v", key, r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.val, call.err = fn()
+}
+
+// WithLoadLock sets how long GetOrLoad holds the cross-instance lock on a key,
+// and so how long other instances wait for it before loading themselves.
+func (tc TypedCache[T]) WithLoadLock(ttl time.Duration) TypedCache[T] {
+	tc.lockTTL = ttl
+	return tc
+}
+
+// WithLogger sets where GetOrLoad logs the cache errors it falls back from.
+func (tc TypedCache[T]) WithLogger(log *zap.Logger) TypedCache[T] {
+	tc.log = log
+	return tc
+}
+
+// GetOrLoad returns the cached value of key, or calls loader and caches its
+// result for ttl. Concurrent misses in this process share one loader call, and
+// on Redis a short lock keeps other instances from loading the same key at once.
+// Cache errors are logged and the loader is called, so an unavailable cache
+// only costs the loads.
+func (tc TypedCache[T]) GetOrLoad(key string, ttl time.Duration, loader func() (T, error)) (T, error) {
+	return tc.GetOrLoadCtx(ctxB, key, ttl, loader)
+}
+
+func (tc TypedCache[T]) GetOrLoadCtx(ctx context.Context, key string, ttl time.Duration, loader func() (T, error)) (T, error) {
+	if value, ok := tc.cached(ctx, key); ok {
+		return value, nil
+	}
+
+	// the load is shared, so it must not end with the ctx of one caller
+	res, err := tc.flight.do(ctx, key, func() (interface{}, error) {
+		return tc.loadLocked(ctxB, key, ttl, loader)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res.(T), nil
+}
+
+func (tc TypedCache[T]) loadLocked(ctx context.Context, key string, ttl time.Duration, loader func() (T, error)) (T, error) {
+	lockName := key + ":load-lock"
+	deadline := time.Now().Add(tc.lockTTL)
+	rc := redisBackend(tc.cache)
+	for rc != nil {
+		lock, err := rc.Lock(ctx, lockName, tc.lockTTL)
+		if err == nil {
+			defer lock.Unlock(ctxB)
+			break
+		}
+		if !errors.Is(err, ErrLockNotObtained) {
+			tc.logger().Warn("cache load lock failed, loading without it",
+				zap.String("key", key),
+				zap.Error(err))
+			break
+		}
+
+		// another instance is loading, wait for its result
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-time.After(loadLockPoll):
+		}
+		if value, ok := tc.cached(ctx, key); ok {
+			return value, nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+
+	// the previous holder may have filled the key just before we got the lock
+	if value, ok := tc.cached(ctx, key); ok {
+		return value, nil
+	}
+
+	value, err := loader()
+	if err != nil {
+		return value, fmt.Errorf("load %s : %w", key, err)
+	}
+	if err := tc.SetCtx(ctx, key, value, ttl); err != nil {
+		tc.logger().Warn("cache store of loaded value failed",
+			zap.String("key", key),
+			zap.Error(err))
+	}
+	return value, nil
+}
+
+// cached reports a hit on key, logging the errors other than a miss.
+func (tc TypedCache[T]) cached(ctx context.Context, key string) (T, bool) {
+	value, err := tc.GetCtx(ctx, key)
+	if err == nil {
+		return value, true
+	}
+	if !errors.Is(err, ErrCacheMiss) && ctx.Err() == nil {
+		tc.logger().Warn("cache read failed, calling the loader",
+			zap.String("key", key),
+			zap.Error(err))
+	}
+	return value, false
+}
+
+func (tc TypedCache[T]) logger() *zap.Logger {
+	if tc.log == nil {
+		return zap.NewNop()
+	}
+	return tc.log
+}
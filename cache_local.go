@@ -0,0 +1,167 @@
+package library
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type EvictionPolicy int
+
+const (
+	// EvictLRU drops the least recently used entry when the store is full.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU drops the least frequently used entry, the oldest on ties.
+	EvictLFU
+)
+
+type localEntry struct {
+	key     string
+	value   string
+	expires time.Time
+	hits    int
+	hasTTL  bool
+}
+
+func (e *localEntry) expired(now time.Time) bool {
+	return e.hasTTL && now.After(e.expires)
+}
+
+// LocalStore is a size-bounded in-process store with per-entry TTL.
+type LocalStore struct {
+	mu     sync.Mutex
+	size   int
+	policy EvictionPolicy
+	items  map[string]*list.Element
+	ll     *list.List
+	sets   int
+}
+
+// sweepEvery is how many Sets an unbounded store takes between sweeps of
+// expired entries, which nothing else would evict.
+const sweepEvery = 1024
+
+func NewLocalStore(size int, policy EvictionPolicy) *LocalStore {
+	return &LocalStore{
+		size:   size,
+		policy: policy,
+		items:  map[string]*list.Element{},
+		ll:     list.New(),
+	}
+}
+
+func (s *LocalStore) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*localEntry)
+	if e.expired(time.Now()) {
+		s.remove(el)
+		return "", false
+	}
+	e.hits++
+	s.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value for ttl; a zero ttl keeps it until evicted.
+func (s *LocalStore) Set(key, value string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &localEntry{key: key, value: value, hasTTL: ttl > 0, expires: time.Now().Add(ttl)}
+	if el, ok := s.items[key]; ok {
+		e.hits = el.Value.(*localEntry).hits
+		el.Value = e
+		s.ll.MoveToFront(el)
+		return
+	}
+
+	if s.size > 0 && s.ll.Len() >= s.size {
+		s.evict()
+	}
+	s.items[key] = s.ll.PushFront(e)
+
+	if s.sets++; s.size == 0 && s.sets%sweepEvery == 0 {
+		s.sweep()
+	}
+}
+
+func (s *LocalStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *LocalStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = map[string]*list.Element{}
+	s.ll.Init()
+}
+
+func (s *LocalStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Keys lists the keys that have not expired.
+func (s *LocalStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	keys := make([]string, 0, s.ll.Len())
+	for el := s.ll.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*localEntry); !e.expired(now) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+func (s *LocalStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.items, el.Value.(*localEntry).key)
+}
+
+func (s *LocalStore) sweep() {
+	now := time.Now()
+	for el := s.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*localEntry).expired(now) {
+			s.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (s *LocalStore) evict() {
+	// expired entries go first whatever the policy
+	now := time.Now()
+	for el := s.ll.Back(); el != nil; el = el.Prev() {
+		if el.Value.(*localEntry).expired(now) {
+			s.remove(el)
+			return
+		}
+	}
+
+	victim := s.ll.Back()
+	if s.policy == EvictLFU {
+		for el := s.ll.Back(); el != nil; el = el.Prev() {
+			if el.Value.(*localEntry).hits < victim.Value.(*localEntry).hits {
+				victim = el
+			}
+		}
+	}
+	if victim != nil {
+		s.remove(victim)
+	}
+}
@@ -0,0 +1,194 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrLockNotObtained = errors.New("lock not obtained")
+	ErrLockNotHeld     = errors.New("lock not held")
+)
+
+// minLockTTL is the PEXPIRE resolution; a shorter ttl would release the lock.
+const minLockTTL = time.Millisecond
+
+func checkLockTTL(name string, ttl time.Duration) error {
+	if ttl < minLockTTL {
+		return fmt.Errorf("lock %s : ttl must be at least %s, got %s", name, minLockTTL, ttl)
+	}
+	return nil
+}
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock is a distributed mutex held in Redis under a random token, so only
+// its holder can release or extend it.
+type Lock struct {
+	cache *RedisCache
+	name  string
+	token string
+	ttl   time.Duration
+
+	once     sync.Once
+	lostOnce sync.Once
+	done     chan struct{}
+	lost     chan struct{}
+}
+
+// acquire sets name to a random token if nobody holds it yet.
+func (c *RedisCache) acquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	token := UUID()
+	ok, err := c.rdb.SetNX(ctx, c.key(name), token, ttl).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// release deletes name only while it still holds token.
+func (c *RedisCache) release(ctx context.Context, name, token string) (bool, error) {
+	n, err := releaseScript.Run(ctx, c.rdb, []string{c.key(name)}, token).Int()
+	return n == 1, err
+}
+
+// Lock makes a single attempt to take name for ttl and returns
+// ErrLockNotObtained when someone else holds it.
+func (c *RedisCache) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	if err := checkLockTTL(name, ttl); err != nil {
+		return nil, err
+	}
+	token, ok, err := c.acquire(ctx, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockNotObtained
+	}
+
+	return &Lock{
+		cache: c,
+		name:  name,
+		token: token,
+		ttl:   ttl,
+		done:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}, nil
+}
+
+// TryLock keeps trying to take name until it succeeds, timeout passes or ctx
+// is done.
+func (c *RedisCache) TryLock(ctx context.Context, name string, ttl, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	wait := 10 * time.Millisecond
+	for {
+		lock, err := c.Lock(ctx, name, ttl)
+		if !errors.Is(err, ErrLockNotObtained) {
+			return lock, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrLockNotObtained
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait < 500*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+func (l *Lock) Name() string {
+	return l.name
+}
+
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Unlock releases the lock and stops any auto-renewal. It returns
+// ErrLockNotHeld when the lock expired or was taken over meanwhile.
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.once.Do(func() { close(l.done) })
+	ok, err := l.cache.release(ctx, l.name, l.token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend resets the lock's expiry to ttl.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if err := checkLockTTL(l.name, ttl); err != nil {
+		return err
+	}
+	n, err := extendScript.Run(ctx, l.cache.rdb, []string{l.cache.key(l.name)}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// TTL returns the time left before the lock expires.
+func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
+	return l.cache.rdb.PTTL(ctx, l.cache.key(l.name)).Result()
+}
+
+// AutoRenew extends the lock every third of its ttl until Unlock is called or
+// ctx is done. If an extension fails the channel returned by Lost is closed.
+func (l *Lock) AutoRenew(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			case <-ticker.C:
+				if err := l.Extend(ctx, l.ttl); err != nil {
+					if ctx.Err() == nil {
+						l.lostOnce.Do(func() { close(l.lost) })
+					}
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Lost is closed when auto-renewal could not keep the lock.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
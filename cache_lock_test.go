@@ -0,0 +1,95 @@
+package library
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheLock(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	c := &RedisCache{rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()}), prefix: "svc"}
+
+	lock, err := c.Lock(ctx, "cron", time.Second)
+	assert.NoError(t, err)
+
+	_, err = c.Lock(ctx, "cron", time.Second)
+	assert.ErrorIs(t, err, ErrLockNotObtained)
+
+	_, err = c.TryLock(ctx, "cron", time.Second, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLockNotObtained)
+
+	assert.NoError(t, lock.Extend(ctx, 5*time.Second))
+	ttl, err := lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, time.Second)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Unlock(ctx)
+	}()
+	second, err := c.TryLock(ctx, "cron", time.Second, time.Second)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, lock.Unlock(ctx), ErrLockNotHeld)
+
+	mr.FastForward(2 * time.Second)
+	assert.ErrorIs(t, second.Extend(ctx, time.Second), ErrLockNotHeld)
+}
+
+func TestCacheLockAutoRenew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewCacheFixture(t)
+
+	lock, err := f.Lock(ctx, "payment", 300*time.Millisecond)
+	assert.NoError(t, err)
+	lock.AutoRenew(ctx)
+
+	// miniredis only expires on FastForward: move past the original ttl in
+	// steps, letting a renewal run between them
+	for i := 0; i < 4; i++ {
+		f.FastForward(200 * time.Millisecond)
+		time.Sleep(150 * time.Millisecond)
+	}
+	f.AssertKeyExists("payment")
+	ttl, err := lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 100*time.Millisecond)
+
+	// once renewal stops the lock expires
+	cancel()
+	time.Sleep(150 * time.Millisecond)
+	f.FastForward(300 * time.Millisecond)
+	f.AssertKeyMissing("payment")
+
+	lock, err = f.Lock(context.Background(), "payment", time.Second)
+	assert.NoError(t, err)
+	lock.AutoRenew(context.Background())
+	f.Delete("payment")
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lost lock was not reported")
+	}
+}
+
+func TestCacheLockInvalidTTL(t *testing.T) {
+	ctx := context.Background()
+	f := NewCacheFixture(t)
+
+	for _, ttl := range []time.Duration{0, -time.Second, 2 * time.Nanosecond, 999 * time.Microsecond} {
+		_, err := f.Lock(ctx, "job", ttl)
+		assert.Error(t, err, ttl)
+	}
+	f.AssertKeyMissing("job")
+
+	lock, err := f.Lock(ctx, "job", time.Second)
+	assert.NoError(t, err)
+	assert.Error(t, lock.Extend(ctx, 500*time.Microsecond))
+	f.AssertKeyExists("job")
+}
@@ -0,0 +1,143 @@
+package library
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// MemoryCache is a Cache kept in process by a LocalStore, with no Redis or
+// miniredis behind it. Namespaces share the store.
+type MemoryCache struct {
+	store      *LocalStore
+	prefix     string
+	separator  string
+	defaultTTL time.Duration
+}
+
+// NewMemoryCache holds at most size entries, evicting the least recently used;
+// a zero size is unbounded.
+func NewMemoryCache(size int, defaultTTL time.Duration) *MemoryCache {
+	return &MemoryCache{store: NewLocalStore(size, EvictLRU), defaultTTL: defaultTTL}
+}
+
+func (c *MemoryCache) key(name string) string {
+	return joinKey(c.prefix, c.separator, name)
+}
+
+func (c *MemoryCache) Namespace(name string) *MemoryCache {
+	child := *c
+	child.prefix = c.key(name)
+	return &child
+}
+
+func (c *MemoryCache) WithNamespace(name string) Cache {
+	return c.Namespace(name)
+}
+
+func (c *MemoryCache) Prefix() string {
+	return c.prefix
+}
+
+func (c *MemoryCache) Set(name string, value string, tm time.Duration) error {
+	return c.SetCtx(ctxB, name, value, tm)
+}
+
+func (c *MemoryCache) SetCtx(ctx context.Context, name string, value string, tm time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	switch tm {
+	case 0:
+		tm = c.defaultTTL
+	case NoExpiry:
+		tm = 0
+	}
+	c.store.Set(c.key(name), value, tm)
+	return nil
+}
+
+func (c *MemoryCache) Get(name string) (string, error) {
+	return c.GetCtx(ctxB, name)
+}
+
+func (c *MemoryCache) GetCtx(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	val, ok := c.store.Get(c.key(name))
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	return val, nil
+}
+
+func (c *MemoryCache) Delete(name string) error {
+	return c.DeleteCtx(ctxB, name)
+}
+
+func (c *MemoryCache) DeleteCtx(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.store.Delete(c.key(name))
+	return nil
+}
+
+func (c *MemoryCache) GetKeys() ([]string, error) {
+	return c.GetKeysCtx(ctxB)
+}
+
+func (c *MemoryCache) GetKeysCtx(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, k := range c.ownKeys() {
+		result = append(result, strings.TrimPrefix(k, c.key("")))
+	}
+	return result, nil
+}
+
+func (c *MemoryCache) ownKeys() []string {
+	keys := c.store.Keys()
+	if c.prefix == "" {
+		return keys
+	}
+	own := keys[:0]
+	for _, k := range keys {
+		if strings.HasPrefix(k, c.key("")) {
+			own = append(own, k)
+		}
+	}
+	return own
+}
+
+func (c *MemoryCache) ClearKeys() error {
+	return c.ClearKeysCtx(ctxB)
+}
+
+func (c *MemoryCache) ClearKeysCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.prefix == "" {
+		return errNoPrefix
+	}
+	for _, k := range c.ownKeys() {
+		c.store.Delete(k)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Ping() bool {
+	return true
+}
+
+func (c *MemoryCache) PingCtx(ctx context.Context) bool {
+	return ctx.Err() == nil
+}
+
+func (c *MemoryCache) Close() error {
+	return nil
+}
@@ -0,0 +1,252 @@
+package library
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CacheMetrics receives cache instrumentation. namespace is the full key
+// prefix of the Cache that ran the command, empty for the root cache.
+type CacheMetrics interface {
+	Hit(namespace string)
+	Miss(namespace string)
+	Error(namespace, command string)
+	Observe(namespace, command string, d time.Duration)
+	Keys(namespace string, n int)
+}
+
+// DefaultLatencyBuckets are the histogram upper bounds, in seconds, used by
+// NewMemoryMetrics when none are given.
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// WithMetrics returns a copy of the cache that reports to m. Namespaces
+// created from the copy report to m too.
+func (c *RedisCache) WithMetrics(m CacheMetrics) *RedisCache {
+	child := *c
+	child.metrics = m
+	return &child
+}
+
+// WithSlowLog returns a copy of the cache that logs, at debug level, every
+// command taking threshold or longer.
+func (c *RedisCache) WithSlowLog(log *zap.Logger, threshold time.Duration) *RedisCache {
+	child := *c
+	child.slowLog = log
+	child.slowThreshold = threshold
+	return &child
+}
+
+func (c *RedisCache) observe(command, name string, start time.Time, err error) {
+	d := time.Since(start)
+	if c.metrics != nil {
+		c.metrics.Observe(c.prefix, command, d)
+		if err != nil && err != ErrCacheMiss {
+			c.metrics.Error(c.prefix, command)
+		}
+	}
+	if c.slowLog != nil && d >= c.slowThreshold {
+		c.slowLog.Debug("slow cache command",
+			zap.String("namespace", c.prefix),
+			zap.String("command", command),
+			zap.String("key", name),
+			zap.Duration("duration", d),
+			zap.Error(err))
+	}
+}
+
+// LatencyHistogram holds cumulative bucket counts, as Prometheus does.
+type LatencyHistogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	s := d.Seconds()
+	for i, le := range h.Buckets {
+		if s <= le {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += s
+}
+
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Keys    int
+	Errors  map[string]uint64
+	Latency map[string]LatencyHistogram
+}
+
+// HitRatio is hits over lookups, zero before the first lookup.
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// MemoryMetrics keeps cache metrics in process and serves them in the
+// Prometheus text format.
+type MemoryMetrics struct {
+	mu      sync.Mutex
+	buckets []float64
+	stats   map[string]*CacheStats
+}
+
+func NewMemoryMetrics(buckets ...float64) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &MemoryMetrics{buckets: b, stats: map[string]*CacheStats{}}
+}
+
+func (m *MemoryMetrics) get(namespace string) *CacheStats {
+	s, ok := m.stats[namespace]
+	if !ok {
+		s = &CacheStats{Errors: map[string]uint64{}, Latency: map[string]LatencyHistogram{}}
+		m.stats[namespace] = s
+	}
+	return s
+}
+
+func (m *MemoryMetrics) Hit(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(namespace).Hits++
+}
+
+func (m *MemoryMetrics) Miss(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(namespace).Misses++
+}
+
+func (m *MemoryMetrics) Error(namespace, command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(namespace).Errors[command]++
+}
+
+func (m *MemoryMetrics) Observe(namespace, command string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(namespace)
+	h, ok := s.Latency[command]
+	if !ok {
+		h = LatencyHistogram{Buckets: m.buckets, Counts: make([]uint64, len(m.buckets))}
+	}
+	h.observe(d)
+	s.Latency[command] = h
+}
+
+func (m *MemoryMetrics) Keys(namespace string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(namespace).Keys = n
+}
+
+// Stats returns a copy of the metrics recorded for namespace.
+func (m *MemoryMetrics) Stats(namespace string) CacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(namespace)
+	out := *s
+	out.Errors = make(map[string]uint64, len(s.Errors))
+	for k, v := range s.Errors {
+		out.Errors[k] = v
+	}
+	out.Latency = make(map[string]LatencyHistogram, len(s.Latency))
+	for k, h := range s.Latency {
+		h.Counts = append([]uint64(nil), h.Counts...)
+		out.Latency[k] = h
+	}
+	return out
+}
+
+// Handler serves the metrics in the Prometheus exposition format, mount it
+// with gin.WrapH.
+func (m *MemoryMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprint(w, m.exposition())
+	})
+}
+
+func (m *MemoryMetrics) exposition() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	namespaces := make([]string, 0, len(m.stats))
+	for ns := range m.stats {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var b strings.Builder
+	header := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("cache_hits_total", "counter", "Cache lookups that found the key.")
+	for _, ns := range namespaces {
+		fmt.Fprintf(&b, "cache_hits_total{namespace=%s} %d\n", promLabel(ns), m.stats[ns].Hits)
+	}
+	header("cache_misses_total", "counter", "Cache lookups that did not find the key.")
+	for _, ns := range namespaces {
+		fmt.Fprintf(&b, "cache_misses_total{namespace=%s} %d\n", promLabel(ns), m.stats[ns].Misses)
+	}
+	header("cache_errors_total", "counter", "Cache commands that failed.")
+	for _, ns := range namespaces {
+		s := m.stats[ns]
+		for _, cmd := range sortedKeys(s.Errors) {
+			fmt.Fprintf(&b, "cache_errors_total{namespace=%s,command=%s} %d\n", promLabel(ns), promLabel(cmd), s.Errors[cmd])
+		}
+	}
+	header("cache_keys", "gauge", "Keys in the namespace when last counted.")
+	for _, ns := range namespaces {
+		fmt.Fprintf(&b, "cache_keys{namespace=%s} %d\n", promLabel(ns), m.stats[ns].Keys)
+	}
+	header("cache_command_duration_seconds", "histogram", "Cache command latency.")
+	for _, ns := range namespaces {
+		s := m.stats[ns]
+		for _, cmd := range sortedKeys(s.Latency) {
+			h := s.Latency[cmd]
+			labels := "namespace=" + promLabel(ns) + ",command=" + promLabel(cmd)
+			for i, le := range h.Buckets {
+				fmt.Fprintf(&b, "cache_command_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+					labels, strconv.FormatFloat(le, 'g', -1, 64), h.Counts[i])
+			}
+			fmt.Fprintf(&b, "cache_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.Count)
+			fmt.Fprintf(&b, "cache_command_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+			fmt.Fprintf(&b, "cache_command_duration_seconds_count{%s} %d\n", labels, h.Count)
+		}
+	}
+	return b.String()
+}
+
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
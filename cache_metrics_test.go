@@ -0,0 +1,75 @@
+package library
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	m := NewMemoryMetrics()
+	root := MockCache(t)
+	c := root.WithMetrics(m)
+	users := c.Namespace("users")
+
+	assert.NoError(t, users.Set("1", "ann", time.Minute))
+	_, err := users.Get("1")
+	assert.NoError(t, err)
+	_, err = users.Get("2")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	keys, err := users.GetKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = users.GetCtx(canceled, "1")
+	assert.Error(t, err)
+
+	s := m.Stats("users")
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(1), s.Misses)
+	assert.Equal(t, 0.5, s.HitRatio())
+	assert.Equal(t, 1, s.Keys)
+	assert.Equal(t, uint64(1), s.Errors["get"])
+	assert.Equal(t, uint64(3), s.Latency["get"].Count)
+	assert.Equal(t, uint64(1), s.Latency["set"].Count)
+
+	// the uninstrumented cache records nothing
+	_, _ = root.Get("users_1")
+	assert.Equal(t, CacheStats{Errors: map[string]uint64{}, Latency: map[string]LatencyHistogram{}}, m.Stats(""))
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "# TYPE cache_hits_total counter")
+	assert.Contains(t, string(body), `cache_hits_total{namespace="users"} 1`)
+	assert.Contains(t, string(body), `cache_errors_total{namespace="users",command="get"} 1`)
+	assert.Contains(t, string(body), `cache_keys{namespace="users"} 1`)
+	assert.Contains(t, string(body), `cache_command_duration_seconds_bucket{namespace="users",command="get",le="+Inf"} 3`)
+	assert.Contains(t, string(body), `cache_command_duration_seconds_count{namespace="users",command="set"} 1`)
+}
+
+func TestCacheSlowLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := MockCache(t)
+
+	fast := c.WithSlowLog(zap.New(core), time.Hour)
+	assert.NoError(t, fast.Set("a", "1", time.Minute))
+	assert.Equal(t, 0, logs.Len())
+
+	slow := c.WithSlowLog(zap.New(core), 0)
+	assert.NoError(t, slow.Set("a", "1", time.Minute))
+	entries := logs.FilterMessage("slow cache command").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "set", entries[0].ContextMap()["command"])
+	assert.Equal(t, "a", entries[0].ContextMap()["key"])
+}
@@ -0,0 +1,106 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Message is a pub/sub message whose payload is JSON.
+type Message struct {
+	Channel string
+	Payload string
+}
+
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(m.Payload), v)
+}
+
+type MessageHandler func(ctx context.Context, msg Message) error
+
+// Publish sends v, encoded as JSON, on channel inside this cache's prefix.
+func (c *RedisCache) Publish(ctx context.Context, channel string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("publish %s : %w", channel, err)
+	}
+	if err := c.rdb.Publish(ctx, c.key(channel), payload).Err(); err != nil {
+		return fmt.Errorf("publish %s : %w", channel, err)
+	}
+	return nil
+}
+
+// Subscription delivers messages to its handler until Close is called or the
+// context given to Subscribe is done.
+type Subscription struct {
+	ps   *redis.PubSub
+	once sync.Once
+	done chan struct{}
+}
+
+// Close stops the subscription and waits for the running handler to return.
+func (s *Subscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		err = s.ps.Close()
+	})
+	<-s.done
+	return err
+}
+
+// Subscribe calls handler for every message on channels. Handler errors are
+// logged and do not stop the subscription.
+func (c *RedisCache) Subscribe(ctx context.Context, log *zap.Logger, handler MessageHandler, channels ...string) (*Subscription, error) {
+	keys := make([]string, len(channels))
+	names := map[string]string{}
+	for i, ch := range channels {
+		keys[i] = c.key(ch)
+		names[keys[i]] = ch
+	}
+
+	ps := c.rdb.Subscribe(ctx, keys...)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("subscribe %v : %w", channels, err)
+	}
+
+	sub := &Subscription{ps: ps, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				sub.once.Do(func() { ps.Close() })
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				msg := Message{Channel: names[m.Channel], Payload: m.Payload}
+				if err := handler(ctx, msg); err != nil {
+					log.Error("handle message",
+						zap.String("channel", msg.Channel),
+						zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// SubscribeJSON is Subscribe with payloads decoded into T.
+func SubscribeJSON[T any](ctx context.Context, c *RedisCache, log *zap.Logger, channel string, handler func(ctx context.Context, v T) error) (*Subscription, error) {
+	return c.Subscribe(ctx, log, func(ctx context.Context, msg Message) error {
+		var v T
+		if err := msg.Decode(&v); err != nil {
+			return fmt.Errorf("decode message : %w", err)
+		}
+		return handler(ctx, v)
+	}, channel)
+}
@@ -0,0 +1,148 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type orderEvent struct {
+	OrderID int    `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+func TestCachePubSub(t *testing.T) {
+	ctx := context.Background()
+	c := MockCache(t)
+
+	received := make(chan orderEvent, 1)
+	sub, err := SubscribeJSON(ctx, c, zap.NewNop(), "orders", func(ctx context.Context, e orderEvent) error {
+		received <- e
+		return nil
+	})
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	assert.NoError(t, c.Publish(ctx, "orders", orderEvent{OrderID: 1, Status: "paid"}))
+	select {
+	case e := <-received:
+		assert.Equal(t, "paid", e.Status)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestStreamConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := MockCache(t)
+
+	var mu sync.Mutex
+	var handled []int
+	failOnce := true
+	handler := func(ctx context.Context, msg StreamMessage) error {
+		var e orderEvent
+		if err := msg.Decode(&e); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if e.OrderID == 2 && failOnce {
+			failOnce = false
+			return errors.New("temporary failure")
+		}
+		handled = append(handled, e.OrderID)
+		return nil
+	}
+
+	sc := NewStreamConsumer(c, "orders", "billing", "worker-1", handler, zap.NewNop())
+	sc.Block = 50 * time.Millisecond
+	sc.ClaimIdle = 0
+	sc.ClaimInterval = 100 * time.Millisecond
+
+	done := make(chan error)
+	go func() { done <- sc.Run(ctx) }()
+
+	for i := 1; i <= 3; i++ {
+		_, err := c.StreamAdd(ctx, "orders", orderEvent{OrderID: i}, 100)
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 3
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.ElementsMatch(t, []int{1, 2, 3}, handled)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not stop")
+	}
+}
+
+func TestStreamConsumerDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewCacheFixture(t)
+
+	var mu sync.Mutex
+	attempts := map[int]int{}
+	handler := func(ctx context.Context, msg StreamMessage) error {
+		var e orderEvent
+		if err := msg.Decode(&e); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[e.OrderID]++
+		if e.OrderID == 2 {
+			return errors.New("poison")
+		}
+		return nil
+	}
+
+	sc := NewStreamConsumer(f.RedisCache, "orders", "billing", "worker-1", handler, zap.NewNop())
+	sc.Block = 20 * time.Millisecond
+	sc.ClaimIdle = 0
+	sc.ClaimInterval = 20 * time.Millisecond
+	sc.MaxDeliveries = 3
+
+	for i := 1; i <= 2; i++ {
+		_, err := f.StreamAdd(ctx, "orders", orderEvent{OrderID: i}, 100)
+		assert.NoError(t, err)
+	}
+	done := make(chan error)
+	go func() { done <- sc.Run(ctx) }()
+
+	assert.Eventually(t, func() bool {
+		n, _ := f.rdb.XLen(ctx, f.key("orders:dead")).Result()
+		return n == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	dead, err := f.rdb.XRange(ctx, f.key("orders:dead"), "-", "+").Result()
+	assert.NoError(t, err)
+	var e orderEvent
+	assert.NoError(t, StreamMessage{Values: dead[0].Values}.Decode(&e))
+	assert.Equal(t, 2, e.OrderID)
+	assert.Equal(t, "3", dead[0].Values["deliveries"])
+
+	pending, err := f.rdb.XPending(ctx, f.key("orders"), "billing").Result()
+	assert.NoError(t, err)
+	assert.Zero(t, pending.Count)
+
+	cancel()
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, attempts[1])
+	assert.Equal(t, 3, attempts[2])
+}
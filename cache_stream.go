@@ -0,0 +1,252 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const streamDataField = "data"
+
+// StreamMessage is one entry read from a Redis stream.
+type StreamMessage struct {
+	ID     string
+	Stream string
+	Values map[string]interface{}
+}
+
+// Decode unmarshals the JSON body written by StreamAdd.
+func (m StreamMessage) Decode(v interface{}) error {
+	data, ok := m.Values[streamDataField].(string)
+	if !ok {
+		return fmt.Errorf("stream message %s has no %q field", m.ID, streamDataField)
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+type StreamHandler func(ctx context.Context, msg StreamMessage) error
+
+// StreamAdd appends v, encoded as JSON, to stream and returns the entry id.
+// maxLen, when positive, approximately caps the stream length.
+func (c *RedisCache) StreamAdd(ctx context.Context, stream string, v interface{}, maxLen int64) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("stream add %s : %w", stream, err)
+	}
+	id, err := c.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: c.key(stream),
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+		Values: map[string]interface{}{streamDataField: string(payload)},
+	}).Result()
+	if err != nil {
+		return "", fmt.Errorf("stream add %s : %w", stream, err)
+	}
+	return id, nil
+}
+
+// StreamConsumer reads a stream as one member of a consumer group. Messages
+// are acknowledged when the handler returns nil; failed ones stay pending
+// and are claimed again once idle for ClaimIdle. A message delivered
+// MaxDeliveries times is moved to the DeadLetter stream instead.
+type StreamConsumer struct {
+	Stream   string
+	Group    string
+	Consumer string
+
+	BatchSize     int64
+	Block         time.Duration
+	ClaimIdle     time.Duration
+	ClaimInterval time.Duration
+
+	// MaxDeliveries of zero redelivers failed messages forever.
+	MaxDeliveries int64
+	// DeadLetter is the stream receiving the messages over MaxDeliveries,
+	// with their original id and delivery count added.
+	DeadLetter string
+
+	cache   *RedisCache
+	handler StreamHandler
+	log     *zap.Logger
+}
+
+func NewStreamConsumer(cache *RedisCache, stream, group, consumer string, handler StreamHandler, log *zap.Logger) *StreamConsumer {
+	return &StreamConsumer{
+		Stream:        stream,
+		Group:         group,
+		Consumer:      consumer,
+		BatchSize:     10,
+		Block:         2 * time.Second,
+		ClaimIdle:     time.Minute,
+		ClaimInterval: 30 * time.Second,
+		MaxDeliveries: 5,
+		DeadLetter:    stream + ":dead",
+		cache:         cache,
+		handler:       handler,
+		log:           log,
+	}
+}
+
+func (sc *StreamConsumer) key() string {
+	return sc.cache.key(sc.Stream)
+}
+
+// Run consumes until ctx is done. The message being handled when ctx is
+// canceled is finished and acknowledged before Run returns.
+func (sc *StreamConsumer) Run(ctx context.Context) error {
+	err := sc.cache.rdb.XGroupCreateMkStream(ctx, sc.key(), sc.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create group %s : %w", sc.Group, err)
+	}
+
+	sc.log.Info("stream consumer started",
+		zap.String("stream", sc.Stream),
+		zap.String("group", sc.Group),
+		zap.String("consumer", sc.Consumer))
+	defer sc.log.Info("stream consumer stopped", zap.String("stream", sc.Stream), zap.String("consumer", sc.Consumer))
+
+	lastClaim := time.Time{}
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if time.Since(lastClaim) >= sc.ClaimInterval {
+			lastClaim = time.Now()
+			if err := sc.claimStale(ctx); err != nil && ctx.Err() == nil {
+				sc.log.Warn("claim stale messages", zap.String("stream", sc.Stream), zap.Error(err))
+			}
+		}
+
+		streams, err := sc.cache.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sc.Group,
+			Consumer: sc.Consumer,
+			Streams:  []string{sc.key(), ">"},
+			Count:    sc.BatchSize,
+			Block:    sc.Block,
+		}).Result()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			sc.log.Error("read stream", zap.String("stream", sc.Stream), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, s := range streams {
+			sc.process(s.Messages)
+		}
+	}
+}
+
+func (sc *StreamConsumer) process(msgs []redis.XMessage) {
+	for _, m := range msgs {
+		msg := StreamMessage{ID: m.ID, Stream: sc.Stream, Values: m.Values}
+		// handlers run to completion even during shutdown
+		if err := sc.handler(ctxB, msg); err != nil {
+			sc.log.Error("handle stream message",
+				zap.String("stream", sc.Stream),
+				zap.String("id", m.ID),
+				zap.Error(err))
+			continue
+		}
+		if err := sc.cache.rdb.XAck(ctxB, sc.key(), sc.Group, m.ID).Err(); err != nil {
+			sc.log.Error("ack stream message",
+				zap.String("stream", sc.Stream),
+				zap.String("id", m.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// claimStale takes over messages other consumers left pending for longer
+// than ClaimIdle and handles them here.
+func (sc *StreamConsumer) claimStale(ctx context.Context) error {
+	pending, err := sc.cache.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: sc.key(),
+		Group:  sc.Group,
+		Start:  "-",
+		End:    "+",
+		Count:  sc.BatchSize,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	deliveries := map[string]int64{}
+	for _, p := range pending {
+		if p.Idle < sc.ClaimIdle {
+			continue
+		}
+		if sc.MaxDeliveries > 0 && p.RetryCount >= sc.MaxDeliveries {
+			deliveries[p.ID] = p.RetryCount
+		}
+		ids = append(ids, p.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	msgs, err := sc.cache.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   sc.key(),
+		Group:    sc.Group,
+		Consumer: sc.Consumer,
+		MinIdle:  sc.ClaimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	sc.log.Info("claimed stale stream messages", zap.String("stream", sc.Stream), zap.Int("count", len(msgs)))
+	retry := msgs[:0]
+	for _, m := range msgs {
+		if n, ok := deliveries[m.ID]; ok {
+			sc.deadLetter(ctx, m, n)
+			continue
+		}
+		retry = append(retry, m)
+	}
+	sc.process(retry)
+	return nil
+}
+
+// deadLetter moves a message that kept failing to the DeadLetter stream.
+func (sc *StreamConsumer) deadLetter(ctx context.Context, m redis.XMessage, deliveries int64) {
+	values := make(map[string]interface{}, len(m.Values)+2)
+	for k, v := range m.Values {
+		values[k] = v
+	}
+	values["original_id"] = m.ID
+	values["deliveries"] = deliveries
+
+	log := sc.log.With(
+		zap.String("stream", sc.Stream),
+		zap.String("id", m.ID),
+		zap.Int64("deliveries", deliveries))
+	err := sc.cache.rdb.XAdd(ctx, &redis.XAddArgs{Stream: sc.cache.key(sc.DeadLetter), Values: values}).Err()
+	if err != nil {
+		log.Error("dead letter stream message", zap.Error(err))
+		return
+	}
+	if err := sc.cache.rdb.XAck(ctx, sc.key(), sc.Group, m.ID).Err(); err != nil {
+		log.Error("ack dead stream message", zap.Error(err))
+		return
+	}
+	log.Warn("stream message moved to dead letter", zap.String("dead_letter", sc.DeadLetter))
+}
@@ -1,9 +1,18 @@
 package library
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,3 +24,246 @@ func TestCache(t *testing.T) {
 	assert.NoError(t, err)
 	t.Logf("value :%s", val)
 }
+
+func TestCacheCtx(t *testing.T) {
+	r := MockCache(t)
+	ctx := context.Background()
+	assert.NoError(t, r.SetCtx(ctx, "key", "val", time.Minute))
+	val, err := r.GetCtx(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "val", val)
+	assert.True(t, r.PingCtx(ctx))
+
+	keys, err := r.GetKeysCtx(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = r.GetCtx(canceled, "key")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// a cache without a prefix would clear the whole database
+	assert.Error(t, r.ClearKeysCtx(ctx))
+	assert.NoError(t, r.Namespace("ns").ClearKeysCtx(ctx))
+	assert.NoError(t, r.DeleteCtx(ctx, "key"))
+}
+
+func TestCacheClusterKeys(t *testing.T) {
+	a, b := miniredis.RunT(t), miniredis.RunT(t)
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: a.Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: b.Addr()}}},
+			}, nil
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	c := &RedisCache{rdb: rdb, prefix: "svc"}
+
+	// the masters are scanned in parallel, into one list
+	var want []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("k%d", i)
+		want = append(want, name)
+		assert.NoError(t, c.Set(name, "v", time.Minute))
+	}
+	assert.NotEmpty(t, a.Keys())
+	assert.NotEmpty(t, b.Keys())
+	keys, err := c.GetKeys()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, want, keys)
+}
+
+func TestCacheNamespaces(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	orders := &RedisCache{rdb: rdb, prefix: "orders", separator: ":"}
+	billing := &RedisCache{rdb: rdb, prefix: "billing"}
+	users := orders.Namespace("users")
+
+	assert.NoError(t, orders.Set("a", "1", time.Minute))
+	assert.NoError(t, users.Set("42", "bob", time.Minute))
+	assert.NoError(t, billing.Set("a", "2", time.Minute))
+	assert.True(t, mr.Exists("orders:users:42"))
+	assert.True(t, mr.Exists("billing_a"))
+
+	keys, err := users.GetKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"42"}, keys)
+
+	keys, err = orders.GetKeys()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "users:42"}, keys)
+
+	assert.NoError(t, orders.ClearKeys())
+	assert.False(t, mr.Exists("orders:a"))
+	assert.False(t, mr.Exists("orders:users:42"))
+	assert.True(t, mr.Exists("billing_a"))
+
+	// without a prefix names are the keys, and GetKeys gives them back as such
+	mr.FlushAll()
+	root := &RedisCache{rdb: rdb}
+	assert.NoError(t, root.Set("a", "1", time.Minute))
+	assert.True(t, mr.Exists("a"))
+	assert.NoError(t, root.Namespace("users").Set("7", "x", time.Minute))
+	assert.True(t, mr.Exists("users_7"))
+	keys, err = root.GetKeys()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "users_7"}, keys)
+	for _, k := range keys {
+		_, err := root.Get(k)
+		assert.NoError(t, err, k)
+	}
+	assert.Error(t, root.ClearKeys())
+
+	// LegacyKeys keeps the "_name" form of earlier versions
+	mr.FlushAll()
+	legacy := &RedisCache{rdb: rdb, legacyKeys: true}
+	assert.NoError(t, legacy.Set("a", "1", time.Minute))
+	assert.True(t, mr.Exists("_a"))
+	keys, err = legacy.GetKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+	v, err := legacy.Get(keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "1", v)
+
+	// a namespace keeps the Redis-only features
+	lock, err := users.Lock(context.Background(), "job", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, mr.Exists("orders:users:job"))
+	assert.NoError(t, lock.Unlock(context.Background()))
+}
+
+func TestNewCacheTopologies(t *testing.T) {
+	mr := miniredis.RunT(t)
+	host, port, _ := strings.Cut(mr.Addr(), ":")
+
+	// expiracy is ignored: zero keeps meaning no expiry
+	c, err := NewCache(CacheConfiguration{URL: host, Port: port, DB: 0}, 60)
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, c.rdb)
+	assert.NoError(t, c.Set("persist", "v", 0))
+	assert.Equal(t, time.Duration(0), mr.TTL("persist"))
+
+	c, err = NewCache(CacheConfiguration{URL: host, Port: port, DefaultTTL: time.Minute}, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set("ttl", "v", 0))
+	assert.Equal(t, time.Minute, mr.TTL("ttl"))
+	assert.NoError(t, c.Set("forever", "v", NoExpiry))
+	assert.True(t, mr.Exists("forever"))
+	assert.Equal(t, time.Duration(0), mr.TTL("forever"))
+
+	c, err = NewCache(CacheConfiguration{ClusterAddrs: []string{"a:7000", "b:7000"}}, 0)
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.ClusterClient{}, c.rdb)
+
+	c, err = NewCache(CacheConfiguration{MasterName: "mymaster", SentinelAddrs: []string{"s:26379"}}, 0)
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, c.rdb)
+
+	_, err = NewCache(CacheConfiguration{SentinelAddrs: []string{"s:26379"}}, 0)
+	assert.ErrorContains(t, err, "MasterName")
+
+	_, err = NewCache(CacheConfiguration{URL: host, Port: port, TLS: true, TLSCAFile: "missing.pem"}, 0)
+	assert.ErrorContains(t, err, "read ca file")
+}
+
+func TestCacheFixture(t *testing.T) {
+	f := NewCacheFixture(t)
+	ctx := context.Background()
+
+	assert.NoError(t, f.SetCtx(ctx, "session", "abc", time.Minute))
+	f.AssertKeyExists("session")
+	f.AssertTTL("session", time.Minute)
+
+	f.FastForward(time.Minute)
+	f.AssertKeyMissing("session")
+
+	path := filepath.Join(t.TempDir(), "seed.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"name":"ann","user":{"id":1}}`), 0o600))
+	f.Seed(map[string]interface{}{"plain": "v", "count": 3})
+	f.SeedFile(path)
+
+	val, err := f.Get("name")
+	assert.NoError(t, err)
+	assert.Equal(t, "ann", val)
+	val, _ = f.Get("user")
+	assert.JSONEq(t, `{"id":1}`, val)
+	val, _ = f.Get("count")
+	assert.Equal(t, "3", val)
+
+	dump := f.DumpKeys()
+	assert.Contains(t, dump, "cache keys (4):")
+	assert.Contains(t, dump, `plain [string] = "v"`)
+	f.AssertTTL("plain", 0)
+
+	// failures and cleanups are reported to the testing.TB given
+	fake := &recordingTB{TB: t}
+	ff := NewCacheFixture(fake)
+	assert.Len(t, fake.cleanups, 2)
+	assert.False(t, ff.AssertKeyExists("missing"))
+	assert.Len(t, fake.errors, 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ff.SeedFile(filepath.Join(t.TempDir(), "missing.json"))
+	}()
+	<-done
+	assert.Len(t, fake.fatals, 1)
+
+	fake.runCleanups()
+	assert.False(t, ff.Ping())
+}
+
+// recordingTB records what a CacheFixture reports instead of failing the
+// test. Fatalf stops the calling goroutine, like testing.T does.
+type recordingTB struct {
+	testing.TB
+	mu       sync.Mutex
+	errors   []string
+	fatals   []string
+	cleanups []func()
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Log(args ...interface{}) {}
+
+func (r *recordingTB) Logf(format string, args ...interface{}) {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Fatalf(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.fatals = append(r.fatals, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (r *recordingTB) Cleanup(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanups = append(r.cleanups, fn)
+}
+
+func (r *recordingTB) runCleanups() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestLocalCacheClose(t *testing.T) {
+	c, err := LocalCache()
+	assert.NoError(t, err)
+	assert.True(t, c.Ping())
+	assert.NoError(t, c.Close())
+	assert.False(t, c.Ping())
+}
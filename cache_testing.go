@@ -0,0 +1,138 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// CacheFixture is a Cache on a miniredis server owned by a test. The server
+// and the client are closed by t.Cleanup. Key names given to the helpers are
+// relative to the cache prefix, like every other Cache method.
+type CacheFixture struct {
+	*RedisCache
+	MR *miniredis.Miniredis
+
+	t testing.TB
+}
+
+func NewCacheFixture(t testing.TB) *CacheFixture {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c := &RedisCache{rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { c.rdb.Close() })
+	return &CacheFixture{RedisCache: c, MR: mr, t: t}
+}
+
+// FastForward moves the server clock so keys expire; miniredis never expires
+// them on its own.
+func (f *CacheFixture) FastForward(d time.Duration) {
+	f.MR.FastForward(d)
+}
+
+func (f *CacheFixture) AssertKeyExists(name string) bool {
+	f.t.Helper()
+	if !f.MR.Exists(f.key(name)) {
+		f.t.Errorf("cache key %q does not exist\n%s", f.key(name), f.dump())
+		return false
+	}
+	return true
+}
+
+func (f *CacheFixture) AssertKeyMissing(name string) bool {
+	f.t.Helper()
+	if f.MR.Exists(f.key(name)) {
+		f.t.Errorf("cache key %q exists", f.key(name))
+		return false
+	}
+	return true
+}
+
+// AssertTTL checks the remaining TTL of name, which only changes on
+// FastForward. Zero means the key has no expiry.
+func (f *CacheFixture) AssertTTL(name string, want time.Duration) bool {
+	f.t.Helper()
+	if !f.AssertKeyExists(name) {
+		return false
+	}
+	if got := f.MR.TTL(f.key(name)); got != want {
+		f.t.Errorf("cache key %q has TTL %s, want %s", f.key(name), got, want)
+		return false
+	}
+	return true
+}
+
+// DumpKeys logs every key on the server with its type, TTL and, for strings,
+// its value, and returns the same text.
+func (f *CacheFixture) DumpKeys() string {
+	f.t.Helper()
+	out := f.dump()
+	f.t.Log(out)
+	return out
+}
+
+func (f *CacheFixture) dump() string {
+	keys := f.MR.Keys()
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cache keys (%d):", len(keys))
+	for _, k := range keys {
+		typ := f.MR.Type(k)
+		fmt.Fprintf(&b, "\n  %s [%s]", k, typ)
+		if ttl := f.MR.TTL(k); ttl > 0 {
+			fmt.Fprintf(&b, " ttl=%s", ttl)
+		}
+		if typ == "string" {
+			val, _ := f.MR.Get(k)
+			fmt.Fprintf(&b, " = %q", val)
+		}
+	}
+	return b.String()
+}
+
+// Seed stores fixtures without expiry. Strings are stored as they are, other
+// values as JSON.
+func (f *CacheFixture) Seed(values map[string]interface{}) {
+	f.t.Helper()
+	for name, v := range values {
+		val, ok := v.(string)
+		if !ok {
+			val = ToJSONString(v)
+		}
+		if err := f.MR.Set(f.key(name), val); err != nil {
+			f.t.Fatalf("seed cache key %q : %s", name, err)
+		}
+	}
+}
+
+// SeedFile seeds from a JSON object file, see Seed.
+func (f *CacheFixture) SeedFile(path string) {
+	f.t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		f.t.Fatalf("seed cache : %s", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		f.t.Fatalf("seed cache from %s : %s", path, err)
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	for name, msg := range raw {
+		var s string
+		if json.Unmarshal(msg, &s) == nil {
+			values[name] = s
+			continue
+		}
+		values[name] = msg
+	}
+	f.Seed(values)
+}
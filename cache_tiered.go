@@ -0,0 +1,300 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const invalidateAll = "*"
+
+type invalidation struct {
+	Node string `json:"node"`
+	Key  string `json:"key"`
+}
+
+const localVersionSlots = 256
+
+// localVersions counts the invalidations of each key slot. A Get fills the
+// local store only if its slot did not change while Redis was read, so an
+// invalidation racing the read is not undone by the stale value.
+type localVersions struct {
+	mu    sync.Mutex
+	slots [localVersionSlots]uint64
+}
+
+func localSlot(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % localVersionSlots)
+}
+
+func (v *localVersions) get(key string) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.slots[localSlot(key)]
+}
+
+// TieredCache puts a LocalStore in front of another Cache. When that cache is
+// Redis, writes and deletes are broadcast over pub/sub so every replica drops
+// its local copy of the key.
+type TieredCache struct {
+	Cache
+
+	local    *LocalStore
+	localTTL time.Duration
+	versions *localVersions
+	redis    *RedisCache
+	node     string
+	channel  string
+	log      *zap.Logger
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+}
+
+// NewTieredCache keeps entries locally for at most localTTL, and never longer
+// than they live in Redis. localTTL must be positive: keys expiring in Redis
+// publish no invalidation, so it bounds how stale a local copy can get. Call
+// Start to receive invalidations from other replicas.
+func NewTieredCache(cache Cache, local *LocalStore, localTTL time.Duration, log *zap.Logger) (*TieredCache, error) {
+	if localTTL <= 0 {
+		return nil, fmt.Errorf("tiered cache : local ttl must be positive, got %s", localTTL)
+	}
+	t := &TieredCache{
+		Cache:    cache,
+		local:    local,
+		localTTL: localTTL,
+		versions: &localVersions{},
+		node:     UUID(),
+		log:      log,
+	}
+	if rc, ok := cache.(*RedisCache); ok {
+		t.redis = rc
+		t.channel = rc.key("l1:invalidate")
+	}
+	return t, nil
+}
+
+// Namespace shares the local store and the invalidations of t.
+func (t *TieredCache) Namespace(name string) *TieredCache {
+	child := &TieredCache{
+		Cache:    t.Cache.WithNamespace(name),
+		local:    t.local,
+		localTTL: t.localTTL,
+		versions: t.versions,
+		node:     t.node,
+		channel:  t.channel,
+		log:      t.log,
+	}
+	child.redis, _ = child.Cache.(*RedisCache)
+	return child
+}
+
+func (t *TieredCache) WithNamespace(name string) Cache {
+	return t.Namespace(name)
+}
+
+// Start subscribes to the invalidation channel until ctx is done or Close is
+// called. It does nothing when the cache behind t is not Redis.
+func (t *TieredCache) Start(ctx context.Context) error {
+	if t.redis == nil {
+		return nil
+	}
+	ps := t.redis.rdb.Subscribe(ctx, t.channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return fmt.Errorf("subscribe %s : %w", t.channel, err)
+	}
+
+	t.mu.Lock()
+	t.pubsub = ps
+	t.mu.Unlock()
+
+	go func() {
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				ps.Close()
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				t.handleInvalidation(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the subscription and closes the cache behind t.
+func (t *TieredCache) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pubsub != nil {
+		t.pubsub.Close()
+		t.pubsub = nil
+	}
+	return t.Cache.Close()
+}
+
+func (t *TieredCache) handleInvalidation(payload string) {
+	var inv invalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		t.log.Warn("invalid cache invalidation message", zap.String("payload", payload), zap.Error(err))
+		return
+	}
+	if inv.Node == t.node {
+		return
+	}
+	t.dropLocal(inv.Key)
+}
+
+// dropLocal removes key, or every key for invalidateAll, from the local store.
+func (t *TieredCache) dropLocal(key string) {
+	t.versions.mu.Lock()
+	defer t.versions.mu.Unlock()
+	if key == invalidateAll {
+		for i := range t.versions.slots {
+			t.versions.slots[i]++
+		}
+		t.local.Clear()
+		return
+	}
+	t.versions.slots[localSlot(key)]++
+	t.local.Delete(key)
+}
+
+// setLocal stores a value this replica just wrote, newer than any Get in
+// flight.
+func (t *TieredCache) setLocal(key, value string, tm time.Duration) {
+	t.versions.mu.Lock()
+	defer t.versions.mu.Unlock()
+	t.versions.slots[localSlot(key)]++
+	t.local.Set(key, value, tm)
+}
+
+// fillLocal stores a value read from the cache behind t unless key was
+// invalidated since version was taken.
+func (t *TieredCache) fillLocal(key, value string, tm time.Duration, version uint64) {
+	t.versions.mu.Lock()
+	defer t.versions.mu.Unlock()
+	if t.versions.slots[localSlot(key)] == version {
+		t.local.Set(key, value, tm)
+	}
+}
+
+func (t *TieredCache) publish(ctx context.Context, key string) {
+	if t.redis == nil {
+		return
+	}
+	msg := ToJSONString(invalidation{Node: t.node, Key: key})
+	if err := t.redis.rdb.Publish(ctx, t.channel, msg).Err(); err != nil {
+		t.log.Warn("publish cache invalidation", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// localKey qualifies name with the namespace, since namespaces share the
+// local store.
+func (t *TieredCache) localKey(name string) string {
+	return joinKey(t.Cache.Prefix(), "", name)
+}
+
+func (t *TieredCache) localFor(tm time.Duration) time.Duration {
+	if t.redis != nil {
+		tm = t.redis.ttl(tm)
+	}
+	if tm > 0 && tm < t.localTTL {
+		return tm
+	}
+	return t.localTTL
+}
+
+func (t *TieredCache) Get(name string) (string, error) {
+	return t.GetCtx(ctxB, name)
+}
+
+func (t *TieredCache) GetCtx(ctx context.Context, name string) (string, error) {
+	if val, ok := t.local.Get(t.localKey(name)); ok {
+		if t.redis != nil && t.redis.metrics != nil {
+			t.redis.metrics.Hit(t.redis.prefix)
+		}
+		return val, nil
+	}
+
+	key := t.localKey(name)
+	version := t.versions.get(key)
+	if t.redis == nil {
+		val, err := t.Cache.GetCtx(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		t.fillLocal(key, val, t.localTTL, version)
+		return val, nil
+	}
+
+	// Redis expiry publishes no invalidation, so the local copy must not
+	// outlive the key
+	val, ttl, err := t.redis.getWithTTL(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	tm := t.localTTL
+	if ttl > 0 && ttl < tm {
+		tm = ttl
+	}
+	if ttl > 0 || ttl == -1 {
+		t.fillLocal(key, val, tm, version)
+	}
+	return val, nil
+}
+
+func (t *TieredCache) Set(name string, value string, tm time.Duration) error {
+	return t.SetCtx(ctxB, name, value, tm)
+}
+
+func (t *TieredCache) SetCtx(ctx context.Context, name string, value string, tm time.Duration) error {
+	key := t.localKey(name)
+	if err := t.Cache.SetCtx(ctx, name, value, tm); err != nil {
+		t.dropLocal(key)
+		return err
+	}
+	t.setLocal(key, value, t.localFor(tm))
+	t.publish(ctx, key)
+	return nil
+}
+
+func (t *TieredCache) Delete(name string) error {
+	return t.DeleteCtx(ctxB, name)
+}
+
+func (t *TieredCache) DeleteCtx(ctx context.Context, name string) error {
+	key := t.localKey(name)
+	t.dropLocal(key)
+	if err := t.Cache.DeleteCtx(ctx, name); err != nil {
+		return err
+	}
+	t.publish(ctx, key)
+	return nil
+}
+
+func (t *TieredCache) ClearKeys() error {
+	return t.ClearKeysCtx(ctxB)
+}
+
+func (t *TieredCache) ClearKeysCtx(ctx context.Context) error {
+	t.dropLocal(invalidateAll)
+	if err := t.Cache.ClearKeysCtx(ctx); err != nil {
+		return err
+	}
+	t.publish(ctx, invalidateAll)
+	return nil
+}
@@ -0,0 +1,124 @@
+package library
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestLocalStoreEviction(t *testing.T) {
+	lru := NewLocalStore(2, EvictLRU)
+	lru.Set("a", "1", 0)
+	lru.Set("b", "2", 0)
+	lru.Get("a")
+	lru.Set("c", "3", 0)
+	_, ok := lru.Get("b")
+	assert.False(t, ok)
+	assert.ElementsMatch(t, []string{"a", "c"}, lru.Keys())
+
+	lfu := NewLocalStore(2, EvictLFU)
+	lfu.Set("a", "1", 0)
+	lfu.Set("b", "2", 0)
+	lfu.Get("a")
+	lfu.Get("a")
+	lfu.Get("b")
+	lfu.Set("c", "3", 0)
+	_, ok = lfu.Get("b")
+	assert.False(t, ok)
+
+	ttl := NewLocalStore(0, EvictLRU)
+	ttl.Set("a", "1", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = ttl.Get("a")
+	assert.False(t, ok)
+}
+
+func TestTieredCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	newReplica := func() *TieredCache {
+		c := &RedisCache{rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()}), prefix: "svc"}
+		tc, err := NewTieredCache(c, NewLocalStore(100, EvictLRU), time.Minute, zap.NewNop())
+		assert.NoError(t, err)
+		assert.NoError(t, tc.Start(ctx))
+		t.Cleanup(func() { tc.Close() })
+		return tc
+	}
+	a, b := newReplica(), newReplica()
+
+	// wait for a's invalidation so it cannot drop what b reads next
+	v0 := b.versions.get(b.localKey("user"))
+	assert.NoError(t, a.Set("user", "v1", time.Minute))
+	assert.Eventually(t, func() bool { return b.versions.get(b.localKey("user")) != v0 }, time.Second, time.Millisecond)
+	val, err := b.Get("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	// served from b's local store even though Redis changed underneath
+	mr.Set("svc_user", "direct")
+	val, _ = b.Get("user")
+	assert.Equal(t, "v1", val)
+
+	assert.NoError(t, a.Set("user", "v2", time.Minute))
+	assert.Eventually(t, func() bool {
+		val, _ := b.Get("user")
+		return val == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, a.Delete("user"))
+	assert.Eventually(t, func() bool {
+		_, err := b.Get("user")
+		return err == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCacheLocalTTL(t *testing.T) {
+	f := NewCacheFixture(t)
+	_, err := NewTieredCache(f.RedisCache, NewLocalStore(10, EvictLRU), 0, zap.NewNop())
+	assert.Error(t, err)
+
+	tc, err := NewTieredCache(f.RedisCache, NewLocalStore(10, EvictLRU), time.Minute, zap.NewNop())
+	assert.NoError(t, err)
+
+	// a key read from Redis stays local no longer than it lives there
+	assert.NoError(t, f.Set("short", "v", 30*time.Millisecond))
+	val, err := tc.Get("short")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", val)
+	_, ok := tc.local.Get(tc.localKey("short"))
+	assert.True(t, ok)
+	time.Sleep(50 * time.Millisecond)
+	_, ok = tc.local.Get(tc.localKey("short"))
+	assert.False(t, ok)
+
+	// keys without expiry are kept for localTTL
+	assert.NoError(t, f.Set("long", "v", 0))
+	_, err = tc.Get("long")
+	assert.NoError(t, err)
+	_, ok = tc.local.Get(tc.localKey("long"))
+	assert.True(t, ok)
+}
+
+func TestTieredCacheInvalidationDuringRead(t *testing.T) {
+	tc, err := NewTieredCache(NewMemoryCache(0, 0), NewLocalStore(10, EvictLRU), time.Minute, zap.NewNop())
+	assert.NoError(t, err)
+	key := tc.localKey("user")
+
+	// an invalidation between the read and the fill wins over the stale value
+	version := tc.versions.get(key)
+	tc.handleInvalidation(ToJSONString(invalidation{Node: "other", Key: key}))
+	tc.fillLocal(key, "stale", time.Minute, version)
+	_, ok := tc.local.Get(key)
+	assert.False(t, ok)
+
+	version = tc.versions.get(key)
+	tc.fillLocal(key, "fresh", time.Minute, version)
+	val, ok := tc.local.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, "fresh", val)
+}
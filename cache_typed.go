@@ -0,0 +1,222 @@
+package library
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+)
+
+// ErrCacheMiss wraps redis.Nil, which Get returned for a missing key before,
+// so errors.Is(err, redis.Nil) keeps working.
+var ErrCacheMiss = fmt.Errorf("cache miss : %w", redis.Nil)
+
+// Codec turns values into the bytes stored in the cache and back.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	// ProtoCodec stores values that marshal themselves, such as generated
+	// protobuf messages exposing Marshal() and Unmarshal([]byte).
+	ProtoCodec Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(protoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("proto codec : %T does not implement Marshal() ([]byte, error)", v)
+	}
+	return m.Marshal()
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	u, ok := v.(protoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("proto codec : %T does not implement Unmarshal([]byte) error", v)
+	}
+	return u.Unmarshal(data)
+}
+
+const (
+	rawValue byte = iota
+	gzipValue
+)
+
+type compressedCodec struct {
+	codec   Codec
+	minSize int
+}
+
+// CompressedCodec gzips values encoded by codec once they reach minSize bytes.
+func CompressedCodec(codec Codec, minSize int) Codec {
+	return compressedCodec{codec: codec, minSize: minSize}
+}
+
+func (c compressedCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < c.minSize {
+		return append([]byte{rawValue}, data...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(gzipValue)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compressedCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return errors.New("compressed codec : empty value")
+	}
+
+	switch data[0] {
+	case rawValue:
+		return c.codec.Unmarshal(data[1:], v)
+	case gzipValue:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		raw, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return err
+		}
+		return c.codec.Unmarshal(raw, v)
+	default:
+		return fmt.Errorf("compressed codec : unknown header %d", data[0])
+	}
+}
+
+// TypedCache stores values of type T in a Cache through a Codec.
+type TypedCache[T any] struct {
+	cache   Cache
+	codec   Codec
+	flight  *loadGroup
+	lockTTL time.Duration
+	log     *zap.Logger
+}
+
+func NewTypedCache[T any](cache Cache, codec Codec) TypedCache[T] {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return TypedCache[T]{
+		cache:   cache,
+		codec:   codec,
+		flight:  &loadGroup{},
+		lockTTL: 5 * time.Second,
+	}
+}
+
+func (tc TypedCache[T]) Set(name string, value T, tm time.Duration) error {
+	return tc.SetCtx(ctxB, name, value, tm)
+}
+
+func (tc TypedCache[T]) SetCtx(ctx context.Context, name string, value T, tm time.Duration) error {
+	data, err := tc.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode %s : %w", name, err)
+	}
+	return tc.cache.SetCtx(ctx, name, string(data), tm)
+}
+
+// Get returns ErrCacheMiss when name is not cached.
+func (tc TypedCache[T]) Get(name string) (T, error) {
+	return tc.GetCtx(ctxB, name)
+}
+
+func (tc TypedCache[T]) GetCtx(ctx context.Context, name string) (T, error) {
+	var value T
+	data, err := tc.cache.GetCtx(ctx, name)
+	if err != nil {
+		return value, err
+	}
+
+	target := interface{}(&value)
+	if rv := reflect.ValueOf(&value).Elem(); rv.Kind() == reflect.Ptr {
+		// decode straight into a fresh *X so ProtoCodec sees its methods
+		rv.Set(reflect.New(rv.Type().Elem()))
+		target = value
+	}
+	if err := tc.codec.Unmarshal([]byte(data), target); err != nil {
+		return value, fmt.Errorf("decode %s : %w", name, err)
+	}
+	return value, nil
+}
+
+func (tc TypedCache[T]) Delete(name string) error {
+	return tc.DeleteCtx(ctxB, name)
+}
+
+func (tc TypedCache[T]) DeleteCtx(ctx context.Context, name string) error {
+	return tc.cache.DeleteCtx(ctx, name)
+}
@@ -0,0 +1,214 @@
+package library
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type cachedUser struct {
+	ID   int
+	Name string
+}
+
+type rawMessage struct {
+	body []byte
+}
+
+func (m *rawMessage) Marshal() ([]byte, error) {
+	return m.body, nil
+}
+
+func (m *rawMessage) Unmarshal(data []byte) error {
+	m.body = append([]byte{}, data...)
+	return nil
+}
+
+func TestTypedCacheCodecs(t *testing.T) {
+	c := MockCache(t)
+	codecs := map[string]Codec{
+		"json":    JSONCodec,
+		"gob":     GobCodec,
+		"msgpack": MsgpackCodec,
+		"gzip":    CompressedCodec(JSONCodec, 64),
+	}
+	for name, codec := range codecs {
+		tc := NewTypedCache[cachedUser](c, codec)
+		user := cachedUser{ID: 1, Name: strings.Repeat("x", 100)}
+		assert.NoError(t, tc.Set(name, user, time.Minute), name)
+		got, err := tc.Get(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, user, got, name)
+	}
+
+	tc := NewTypedCache[cachedUser](c, nil)
+	_, err := tc.Get("missing")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	_, err = c.Get("missing")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.ErrorIs(t, err, redis.Nil)
+}
+
+func TestTypedCacheProtoCodec(t *testing.T) {
+	tc := NewTypedCache[*rawMessage](MockCache(t), ProtoCodec)
+	assert.NoError(t, tc.Set("msg", &rawMessage{body: []byte{1, 2, 3}}, time.Minute))
+	got, err := tc.Get("msg")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, got.body)
+}
+
+func TestTypedCacheGetOrLoad(t *testing.T) {
+	c := MockCache(t)
+	tc := NewTypedCache[cachedUser](c, JSONCodec)
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() (cachedUser, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return cachedUser{ID: 7, Name: "loaded"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]cachedUser, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u, err := tc.GetOrLoad("user:7", time.Minute, loader)
+			assert.NoError(t, err)
+			results[i] = u
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, u := range results {
+		assert.Equal(t, "loaded", u.Name)
+	}
+
+	// a second instance waits on the Redis lock instead of loading again
+	other := NewTypedCache[cachedUser](c, JSONCodec)
+	assert.NoError(t, other.Delete("user:7"))
+	_, err := c.Lock(context.Background(), "user:7:load-lock", time.Second)
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tc.Set("user:7", cachedUser{ID: 7, Name: "from other instance"}, time.Minute)
+	}()
+	u, err := other.GetOrLoad("user:7", time.Minute, loader)
+	assert.NoError(t, err)
+	assert.Equal(t, "from other instance", u.Name)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTypedCacheGetOrLoadPanic(t *testing.T) {
+	tc := NewTypedCache[cachedUser](MockCache(t), JSONCodec)
+
+	release := make(chan struct{})
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := tc.GetOrLoad("user:1", time.Minute, func() (cachedUser, error) {
+				<-release
+				panic("boom")
+			})
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorContains(t, err, "panic : boom")
+		case <-time.After(2 * time.Second):
+			t.Fatal("waiter blocked after the loader panicked")
+		}
+	}
+
+	u, err := tc.GetOrLoad("user:1", time.Minute, func() (cachedUser, error) {
+		return cachedUser{ID: 1}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, u.ID)
+}
+
+func TestTypedCacheGetOrLoadCallerCanceled(t *testing.T) {
+	tc := NewTypedCache[cachedUser](MockCache(t), JSONCodec)
+	release := make(chan struct{})
+	loader := func() (cachedUser, error) {
+		<-release
+		return cachedUser{ID: 3}, nil
+	}
+
+	// the caller that started the load gives up, the others still get it
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := tc.GetOrLoadCtx(ctx, "user:3", time.Minute, loader)
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	second := make(chan cachedUser, 1)
+	go func() {
+		u, err := tc.GetOrLoad("user:3", time.Minute, loader)
+		assert.NoError(t, err)
+		second <- u
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-first:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("canceled caller kept waiting for the load")
+	}
+	close(release)
+	select {
+	case u := <-second:
+		assert.Equal(t, 3, u.ID)
+	case <-time.After(time.Second):
+		t.Fatal("waiter did not get the shared load")
+	}
+}
+
+func TestTypedCacheGetOrLoadCacheDown(t *testing.T) {
+	f := NewCacheFixture(t)
+	core, logs := observer.New(zap.WarnLevel)
+	tc := NewTypedCache[cachedUser](f.RedisCache, JSONCodec).WithLogger(zap.New(core))
+	f.MR.SetError("LOADING server is loading")
+
+	var calls int
+	u, err := tc.GetOrLoad("user:2", time.Minute, func() (cachedUser, error) {
+		calls++
+		return cachedUser{ID: 2}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, u.ID)
+	assert.Equal(t, 1, calls)
+	assert.NotZero(t, logs.FilterMessage("cache read failed, calling the loader").Len())
+
+	// a value that does not decode is loaded again too
+	f.MR.SetError("")
+	assert.NoError(t, f.Set("user:2", "not json", time.Minute))
+	u, err = tc.GetOrLoad("user:2", time.Minute, func() (cachedUser, error) {
+		calls++
+		return cachedUser{ID: 2, Name: "reloaded"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "reloaded", u.Name)
+	assert.Equal(t, 2, calls)
+}
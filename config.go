@@ -0,0 +1,347 @@
+package library
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrEnvMissing = errors.New("required variable is not set")
+
+// EnvDecoder is implemented by types that parse their own value from an
+// environment variable.
+type EnvDecoder interface {
+	DecodeEnv(value string) error
+}
+
+// FieldError describes a problem with a single configuration field.
+type FieldError struct {
+	Field string
+	Key   string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %s", e.Field, e.Key, e.Err)
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors aggregates every FieldError found while processing a struct.
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, len(fe))
+	for i, e := range fe {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d configuration error(s): %s", len(fe), strings.Join(msgs, "; "))
+}
+
+type EnvOption func(*envLoader)
+
+// WithEnvPrefix prepends prefix to every variable name looked up.
+func WithEnvPrefix(prefix string) EnvOption {
+	return func(l *envLoader) {
+		l.prefix = prefix
+	}
+}
+
+// WithEnvParser registers a custom parser for fields of type T.
+func WithEnvParser[T any](fn func(string) (T, error)) EnvOption {
+	return func(l *envLoader) {
+		l.parsers[reflect.TypeOf((*T)(nil)).Elem()] = func(s string) (interface{}, error) {
+			return fn(s)
+		}
+	}
+}
+
+func withEnvLookup(lookup func(string) (string, bool)) EnvOption {
+	return func(l *envLoader) {
+		l.lookup = lookup
+	}
+}
+
+const (
+	originLookup = iota
+	originDefaultTag
+	originPreset
+)
+
+func withEnvOrigin(fn func(field, key string, origin int)) EnvOption {
+	return func(l *envLoader) {
+		l.origin = fn
+	}
+}
+
+type envLoader struct {
+	prefix  string
+	lookup  func(string) (string, bool)
+	origin  func(field, key string, origin int)
+	parsers map[reflect.Type]func(string) (interface{}, error)
+	errs    FieldErrors
+}
+
+// LoadEnv fills dst, a pointer to a struct, from environment variables
+// described by `env:"NAME,required"` and `default:"value"` field tags.
+// Nested structs are walked, optionally under an `envPrefix:"PREFIX_"` tag.
+// Fields that are already set keep their value when the variable is missing,
+// so dst can carry defaults of its own. NAME_FILE is read when NAME is unset.
+// Every missing or unparsable variable is reported in the returned FieldErrors.
+func LoadEnv(dst interface{}, opts ...EnvOption) error {
+	l := &envLoader{
+		lookup:  os.LookupEnv,
+		parsers: map[reflect.Type]func(string) (interface{}, error){},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("load env : destination must be a non-nil pointer to struct, got %T", dst)
+	}
+
+	l.loadStruct(rv.Elem(), "", l.prefix)
+	if len(l.errs) > 0 {
+		return l.errs
+	}
+	return nil
+}
+
+func (l *envLoader) loadStruct(rv reflect.Value, path, prefix string) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fv := rv.Field(i)
+		fieldPath := sf.Name
+		if path != "" {
+			fieldPath = path + "." + sf.Name
+		}
+
+		tag, hasTag := sf.Tag.Lookup("env")
+		if tag == "-" {
+			continue
+		}
+		if !hasTag {
+			if l.isNested(fv) {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						fv.Set(reflect.New(fv.Type().Elem()))
+					}
+					fv = fv.Elem()
+				}
+				l.loadStruct(fv, fieldPath, prefix+sf.Tag.Get("envPrefix"))
+			}
+			continue
+		}
+
+		name, opts := parseEnvTag(tag)
+		key := prefix + name
+		origin := originLookup
+		val, ok := l.lookup(key)
+		if !ok {
+			var err error
+			if val, ok, err = l.lookupFile(key); err != nil {
+				l.errs = append(l.errs, FieldError{Field: fieldPath, Key: key + "_FILE", Err: err})
+				continue
+			}
+		}
+		if !ok && fv.IsZero() {
+			origin = originDefaultTag
+			val, ok = sf.Tag.Lookup("default")
+		}
+		if !ok {
+			if !fv.IsZero() {
+				l.report(fieldPath, key, originPreset)
+			} else if opts["required"] {
+				l.errs = append(l.errs, FieldError{Field: fieldPath, Key: key, Err: ErrEnvMissing})
+			}
+			continue
+		}
+		l.report(fieldPath, key, origin)
+
+		if err := l.setValue(fv, val, sf.Tag); err != nil {
+			l.errs = append(l.errs, FieldError{Field: fieldPath, Key: key, Err: err})
+		}
+	}
+}
+
+// lookupFile follows the Docker secrets convention: when NAME is unset,
+// NAME_FILE may point to a file holding the value.
+func (l *envLoader) lookupFile(key string) (string, bool, error) {
+	path, ok := l.lookup(key + "_FILE")
+	if !ok || path == "" {
+		return "", false, nil
+	}
+	s, err := SecretFromFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return s.Reveal(), true, nil
+}
+
+func (l *envLoader) report(field, key string, origin int) {
+	if l.origin != nil {
+		l.origin(field, key, origin)
+	}
+}
+
+func (l *envLoader) isNested(fv reflect.Value) bool {
+	t := fv.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	if _, ok := l.parsers[t]; ok {
+		return false
+	}
+	return !implementsDecoder(t)
+}
+
+func implementsDecoder(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(reflect.TypeOf((*EnvDecoder)(nil)).Elem()) ||
+		pt.Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem())
+}
+
+func parseEnvTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	opts := map[string]bool{}
+	for _, p := range parts[1:] {
+		opts[strings.TrimSpace(p)] = true
+	}
+	return strings.TrimSpace(parts[0]), opts
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (l *envLoader) setValue(fv reflect.Value, val string, tag reflect.StructTag) error {
+	if fn, ok := l.parsers[fv.Type()]; ok {
+		parsed, err := fn(val)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return l.setValue(fv.Elem(), val, tag)
+	}
+
+	if fv.CanAddr() {
+		switch d := fv.Addr().Interface().(type) {
+		case EnvDecoder:
+			return d.DecodeEnv(val)
+		case encoding.TextUnmarshaler:
+			return d.UnmarshalText([]byte(val))
+		}
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		sep := tag.Get("envSeparator")
+		if sep == "" {
+			sep = ","
+		}
+		parts := splitEnvList(val, sep)
+		sl := reflect.MakeSlice(fv.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := l.setValue(sl.Index(i), p, ""); err != nil {
+				return fmt.Errorf("element %d : %w", i, err)
+			}
+		}
+		fv.Set(sl)
+	case reflect.Map:
+		sep := tag.Get("envSeparator")
+		if sep == "" {
+			sep = ","
+		}
+		m := reflect.MakeMap(fv.Type())
+		for _, pair := range splitEnvList(val, sep) {
+			kv := strings.SplitN(pair, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid map entry %q, expected key:value", pair)
+			}
+			k := reflect.New(fv.Type().Key()).Elem()
+			if err := l.setValue(k, strings.TrimSpace(kv[0]), ""); err != nil {
+				return fmt.Errorf("map key %q : %w", kv[0], err)
+			}
+			v := reflect.New(fv.Type().Elem()).Elem()
+			if err := l.setValue(v, strings.TrimSpace(kv[1]), ""); err != nil {
+				return fmt.Errorf("map value %q : %w", kv[1], err)
+			}
+			m.SetMapIndex(k, v)
+		}
+		fv.Set(m)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
+
+func splitEnvList(val, sep string) []string {
+	if strings.TrimSpace(val) == "" {
+		return nil
+	}
+	parts := strings.Split(val, sep)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
@@ -0,0 +1,294 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSource provides configuration values keyed by environment variable name.
+type ConfigSource interface {
+	Name() string
+	Values() (map[string]string, error)
+}
+
+// ConfigOrigin tells where the final value of a field came from.
+type ConfigOrigin struct {
+	Field  string
+	Key    string
+	Source string
+}
+
+type ConfigReport []ConfigOrigin
+
+func (r ConfigReport) String() string {
+	lines := make([]string, len(r))
+	for i, o := range r {
+		lines[i] = fmt.Sprintf("%s (%s) <- %s", o.Field, o.Key, o.Source)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Source returns the origin of field, or an empty string if it was not set.
+func (r ConfigReport) Source(field string) string {
+	for _, o := range r {
+		if o.Field == field {
+			return o.Source
+		}
+	}
+	return ""
+}
+
+const (
+	SourceDefaults   = "defaults"
+	SourceDefaultTag = "default tag"
+)
+
+// ConfigLoader merges its sources in order, later sources overriding earlier
+// ones, on top of the Defaults struct and the `default` tags of the target.
+type ConfigLoader struct {
+	Defaults interface{}
+	Sources  []ConfigSource
+	Options  []EnvOption
+}
+
+// NewConfigLoader builds the usual layering: config file, .env file, then the
+// process environment. Empty paths are skipped and missing files are ignored.
+func NewConfigLoader(defaults interface{}, configFile, envFile string) *ConfigLoader {
+	cl := &ConfigLoader{Defaults: defaults}
+	if configFile != "" {
+		cl.Sources = append(cl.Sources, OptionalSource(FileSource(configFile)))
+	}
+	if envFile != "" {
+		cl.Sources = append(cl.Sources, OptionalSource(DotEnvSource(envFile)))
+	}
+	cl.Sources = append(cl.Sources, EnvironmentSource())
+	return cl
+}
+
+// Load fills dst, a pointer to a struct tagged for LoadEnv, and reports which
+// source provided each field.
+func (cl *ConfigLoader) Load(dst interface{}) (ConfigReport, error) {
+	handleErr := func(err error) (ConfigReport, error) {
+		return nil, fmt.Errorf("load config : %w", err)
+	}
+
+	if cl.Defaults != nil {
+		dv := reflect.ValueOf(dst)
+		def := reflect.Indirect(reflect.ValueOf(cl.Defaults))
+		if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Type() != def.Type() {
+			return handleErr(fmt.Errorf("defaults %T does not match destination %T", cl.Defaults, dst))
+		}
+		dv.Elem().Set(def)
+	}
+
+	values := map[string]string{}
+	origins := map[string]string{}
+	for _, src := range cl.Sources {
+		vals, err := src.Values()
+		if err != nil {
+			return handleErr(fmt.Errorf("source %s (%w)", src.Name(), err))
+		}
+		for k, v := range vals {
+			values[k] = v
+			origins[k] = src.Name()
+		}
+	}
+
+	var report ConfigReport
+	opts := append([]EnvOption{
+		withEnvLookup(func(key string) (string, bool) {
+			v, ok := values[key]
+			return v, ok
+		}),
+		withEnvOrigin(func(field, key string, origin int) {
+			src, ok := origins[key]
+			if !ok {
+				src = origins[key+"_FILE"]
+			}
+			switch origin {
+			case originDefaultTag:
+				src = SourceDefaultTag
+			case originPreset:
+				src = SourceDefaults
+			}
+			report = append(report, ConfigOrigin{Field: field, Key: key, Source: src})
+		}),
+	}, cl.Options...)
+
+	if err := LoadEnv(dst, opts...); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+type sourceFunc struct {
+	name string
+	path string
+	fn   func() (map[string]string, error)
+}
+
+func (s sourceFunc) Name() string {
+	return s.name
+}
+
+// Path is the file behind the source, empty when it is not file based.
+func (s sourceFunc) Path() string {
+	return s.path
+}
+
+func (s sourceFunc) Values() (map[string]string, error) {
+	return s.fn()
+}
+
+// EnvironmentSource reads the process environment.
+func EnvironmentSource() ConfigSource {
+	return sourceFunc{name: "env", fn: func() (map[string]string, error) {
+		vals := map[string]string{}
+		for _, kv := range os.Environ() {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 2 {
+				vals[parts[0]] = parts[1]
+			}
+		}
+		return vals, nil
+	}}
+}
+
+// MapSource serves fixed values, mostly useful in tests.
+func MapSource(name string, vals map[string]string) ConfigSource {
+	return sourceFunc{name: name, fn: func() (map[string]string, error) {
+		return vals, nil
+	}}
+}
+
+// OptionalSource ignores a missing file behind src.
+func OptionalSource(src ConfigSource) ConfigSource {
+	return sourceFunc{name: src.Name(), path: sourcePath(src), fn: func() (map[string]string, error) {
+		vals, err := src.Values()
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return vals, err
+	}}
+}
+
+func sourcePath(src ConfigSource) string {
+	if ps, ok := src.(interface{ Path() string }); ok {
+		return ps.Path()
+	}
+	return ""
+}
+
+// DotEnvSource reads KEY=VALUE lines from a .env file.
+func DotEnvSource(path string) ConfigSource {
+	return sourceFunc{name: "dotenv:" + path, path: path, fn: func() (map[string]string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseDotEnv(bufio.NewScanner(f))
+	}}
+}
+
+func parseDotEnv(sc *bufio.Scanner) (map[string]string, error) {
+	vals := map[string]string{}
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d : expected KEY=VALUE", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		val := strings.TrimSpace(parts[1])
+		switch {
+		case strings.HasPrefix(val, `"`):
+			unq, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, fmt.Errorf("line %d : %w", line, err)
+			}
+			val = unq
+		case strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") && len(val) > 1:
+			val = val[1 : len(val)-1]
+		default:
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = strings.TrimSpace(val[:i])
+			}
+		}
+		vals[key] = val
+	}
+	return vals, sc.Err()
+}
+
+// FileSource reads a YAML or JSON file, chosen by extension. Nested keys are
+// flattened into variable names, so `db: {host: x}` provides DB_HOST.
+func FileSource(path string) ConfigSource {
+	return sourceFunc{name: "file:" + path, path: path, fn: func() (map[string]string, error) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc map[string]interface{}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(raw, &doc)
+		case ".json":
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.UseNumber()
+			err = dec.Decode(&doc)
+		default:
+			return nil, fmt.Errorf("unsupported config file type %q", filepath.Ext(path))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		vals := map[string]string{}
+		flattenConfig("", doc, vals)
+		return vals, nil
+	}}
+}
+
+func flattenConfig(prefix string, val interface{}, out map[string]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			flattenConfig(configKey(prefix, k), child, out)
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		out[prefix] = ""
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+func configKey(prefix, key string) string {
+	key = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
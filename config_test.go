@@ -0,0 +1,228 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type upperString string
+
+func (u *upperString) DecodeEnv(val string) error {
+	*u = upperString(strings.ToUpper(val))
+	return nil
+}
+
+type testServiceConfig struct {
+	Name    string          `env:"NAME,required"`
+	Timeout time.Duration   `env:"TIMEOUT" default:"5s"`
+	Hosts   []string        `env:"HOSTS" envSeparator:";"`
+	Labels  map[string]int  `env:"LABELS"`
+	Mode    upperString     `env:"MODE"`
+	DB      DBConfiguration `envPrefix:"APP_"`
+	Cache   CacheConfiguration
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("SVC_NAME", "orders")
+	t.Setenv("SVC_HOSTS", "a ; b;c")
+	t.Setenv("SVC_LABELS", "x:1,y:2")
+	t.Setenv("SVC_MODE", "fast")
+	t.Setenv("SVC_APP_DB_HOST", "localhost")
+	t.Setenv("SVC_APP_DB_NAME", "orders")
+	t.Setenv("SVC_APP_DB_USERNAME", "admin")
+	t.Setenv("SVC_CACHE_URL", "localhost")
+
+	var cfg testServiceConfig
+	err := LoadEnv(&cfg, WithEnvPrefix("SVC_"))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", cfg.Name)
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+	assert.Equal(t, []string{"a", "b", "c"}, cfg.Hosts)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, cfg.Labels)
+	assert.Equal(t, upperString("FAST"), cfg.Mode)
+	assert.Equal(t, "localhost", cfg.DB.Host)
+	assert.Equal(t, "5432", cfg.DB.Port)
+	assert.Equal(t, 10, cfg.DB.MaxOpenConn)
+	assert.Equal(t, "6379", cfg.Cache.Port)
+}
+
+func TestLoadEnvAggregatesErrors(t *testing.T) {
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_MAX_OPEN_CONN", "ten")
+
+	var cfg DBConfiguration
+	err := LoadEnv(&cfg)
+
+	var fe FieldErrors
+	assert.True(t, errors.As(err, &fe))
+	assert.Len(t, fe, 3)
+	assert.ErrorIs(t, fe[0], ErrEnvMissing)
+	assert.Equal(t, "DB_NAME", fe[0].Key)
+	assert.Equal(t, "DB_USERNAME", fe[1].Key)
+	assert.Equal(t, "MaxOpenConn", fe[2].Field)
+}
+
+func TestLoadEnvCustomParser(t *testing.T) {
+	t.Setenv("START", "2023-01-02")
+
+	var cfg struct {
+		Start time.Time `env:"START"`
+	}
+	err := LoadEnv(&cfg, WithEnvParser(func(s string) (time.Time, error) {
+		return time.Parse("2006-01-02", s)
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, 2023, cfg.Start.Year())
+}
+
+func TestConfigLoaderLayers(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := dir + "/config.yaml"
+	envPath := dir + "/.env"
+	assert.NoError(t, os.WriteFile(yamlPath, []byte("db:\n  host: file-host\n  name: orders\n  username: file-user\ncache:\n  url: redis\n"), 0o600))
+	assert.NoError(t, os.WriteFile(envPath, []byte("# local overrides\nDB_USERNAME=dotenv-user\nexport DB_PASSWORD=\"s3cr3t\"\n"), 0o600))
+	t.Setenv("DB_HOST", "env-host")
+
+	loader := NewConfigLoader(DBConfiguration{MaxOpenConn: 50}, yamlPath, envPath)
+	var db DBConfiguration
+	report, err := loader.Load(&db)
+	assert.NoError(t, err)
+	assert.Equal(t, "env-host", db.Host)
+	assert.Equal(t, "orders", db.DBName)
+	assert.Equal(t, "dotenv-user", db.Username)
+	assert.Equal(t, 50, db.MaxOpenConn)
+	assert.Equal(t, "env", report.Source("Host"))
+	assert.Equal(t, "file:"+yamlPath, report.Source("DBName"))
+	assert.Equal(t, "dotenv:"+envPath, report.Source("Username"))
+	assert.Equal(t, SourceDefaults, report.Source("MaxOpenConn"))
+	assert.Equal(t, SourceDefaultTag, report.Source("Port"))
+
+	var cache CacheConfiguration
+	_, err = NewConfigLoader(nil, yamlPath, "").Load(&cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "redis", cache.URL)
+}
+
+type watchedConfig struct {
+	LogLevel string `env:"LOG_LEVEL" default:"info"`
+	Workers  int    `env:"WORKERS" default:"1"`
+}
+
+func TestConfigWatcherReload(t *testing.T) {
+	path := t.TempDir() + "/.env"
+	assert.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=info\nWORKERS=2\n"), 0o600))
+
+	loader := &ConfigLoader{Sources: []ConfigSource{DotEnvSource(path)}}
+	w, err := NewConfigWatcher(loader, zap.NewNop(), func(c watchedConfig) error {
+		if c.Workers < 1 {
+			return errors.New("workers must be positive")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	var changes []ConfigChange[watchedConfig]
+	w.Subscribe(func(c ConfigChange[watchedConfig]) {
+		changes = append(changes, c)
+		if c.HasChanged("LogLevel") {
+			assert.NoError(t, level.UnmarshalText([]byte(c.New.LogLevel)))
+		}
+	})
+
+	assert.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=debug\nWORKERS=2\n"), 0o600))
+	assert.NoError(t, w.Reload())
+	assert.Len(t, changes, 1)
+	assert.Equal(t, []string{"LogLevel"}, changes[0].Changed)
+	assert.Equal(t, zap.DebugLevel, level.Level())
+
+	assert.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=info\nWORKERS=0\n"), 0o600))
+	assert.Error(t, w.Reload())
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "debug", w.Current().LogLevel)
+}
+
+type diffInner struct {
+	Host string
+	Port int
+}
+
+type diffConfig struct {
+	Name    string
+	Inner   diffInner
+	Started time.Time
+	Opaque  diffOpaque
+	token   string
+}
+
+type diffOpaque struct {
+	Host   string
+	secret string
+}
+
+func TestConfigDiffFields(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := diffConfig{Name: "a", Inner: diffInner{Host: "x"}, Started: start, token: "t1"}
+	cur := old
+	cur.token = "t2"
+	assert.Empty(t, diffFields(reflect.ValueOf(old), reflect.ValueOf(cur), ""))
+
+	// exported structs are walked, the others compared whole
+	cur.Inner.Host = "y"
+	cur.Started = start.Add(time.Hour)
+	cur.Opaque.secret = "s"
+	assert.Equal(t, []string{"Inner.Host", "Started", "Opaque"}, diffFields(reflect.ValueOf(old), reflect.ValueOf(cur), ""))
+}
+
+func TestConfigWatcherConcurrentReload(t *testing.T) {
+	path := t.TempDir() + "/.env"
+	assert.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=info\n"), 0o600))
+	w, err := NewConfigWatcher[watchedConfig](&ConfigLoader{Sources: []ConfigSource{DotEnvSource(path)}}, zap.NewNop(), nil)
+	assert.NoError(t, err)
+	w.Interval = 0
+
+	var notified []string
+	w.Subscribe(func(c ConfigChange[watchedConfig]) {
+		notified = append(notified, c.Old.LogLevel+">"+c.New.LogLevel)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Watch(ctx)
+		close(done)
+	}()
+
+	levels := []string{"debug", "warn", "error", "info"}
+	var wg sync.WaitGroup
+	for _, level := range levels {
+		assert.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL="+level+"\n"), 0o600))
+		wg.Add(2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer wg.Done()
+				w.Reload()
+			}()
+		}
+		wg.Wait()
+	}
+	cancel()
+	<-done
+
+	// reloads that ran one after the other chain old to new
+	prev := "info"
+	for _, n := range notified {
+		assert.True(t, strings.HasPrefix(n, prev+">"), "%v", notified)
+		prev = strings.SplitN(n, ">", 2)[1]
+	}
+	assert.Equal(t, "info", w.Current().LogLevel)
+}
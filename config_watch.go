@@ -0,0 +1,218 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ConfigChange is published to subscribers after a successful reload.
+type ConfigChange[T any] struct {
+	Old     T
+	New     T
+	Changed []string
+}
+
+// HasChanged reports whether field, or any field nested below it, changed.
+func (c ConfigChange[T]) HasChanged(field string) bool {
+	for _, f := range c.Changed {
+		if f == field || len(f) > len(field) && f[:len(field)] == field && f[len(field)] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigWatcher keeps the current configuration of type T and reloads it when
+// one of the loader's files changes or the process receives SIGHUP.
+type ConfigWatcher[T any] struct {
+	Interval time.Duration
+
+	loader   *ConfigLoader
+	log      *zap.Logger
+	validate func(T) error
+
+	// reloadMu serializes reloads so the swap and the notifications of two
+	// reloads never interleave.
+	reloadMu sync.Mutex
+
+	mu      sync.RWMutex
+	current T
+	subs    []func(ConfigChange[T])
+	mtimes  map[string]time.Time
+}
+
+// NewConfigWatcher performs the initial load. Every load runs ValidateStruct
+// and then validate, which may be nil; when either fails on a later reload the
+// previous configuration stays active.
+func NewConfigWatcher[T any](loader *ConfigLoader, log *zap.Logger, validate func(T) error) (*ConfigWatcher[T], error) {
+	w := &ConfigWatcher[T]{
+		Interval: 2 * time.Second,
+		loader:   loader,
+		log:      log,
+		validate: validate,
+		mtimes:   map[string]time.Time{},
+	}
+
+	cfg, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.current = cfg
+	w.mtimes = w.fileTimes()
+
+	return w, nil
+}
+
+// Current returns the active configuration.
+func (w *ConfigWatcher[T]) Current() T {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Subscribe registers fn to be called after every reload that changed something.
+// Settings a running service can take are held in runtime-safe values, like the
+// zap.AtomicLevel of NewLoggerWithLevel or the HTTPTimeout of an HttpClient.
+func (w *ConfigWatcher[T]) Subscribe(fn func(ConfigChange[T])) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, fn)
+}
+
+func (w *ConfigWatcher[T]) load() (T, error) {
+	var cfg T
+	if _, err := w.loader.Load(&cfg); err != nil {
+		return cfg, err
+	}
+	if rv := reflect.Indirect(reflect.ValueOf(cfg)); rv.Kind() == reflect.Struct {
+		if err := ValidateStruct(cfg); err != nil {
+			return cfg, fmt.Errorf("validate config : %w", err)
+		}
+	}
+	if w.validate != nil {
+		if err := w.validate(cfg); err != nil {
+			return cfg, fmt.Errorf("validate config : %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Reload re-reads every source. On failure the current configuration is kept
+// and the error is logged and returned.
+func (w *ConfigWatcher[T]) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	cfg, err := w.load()
+	if err != nil {
+		w.log.Error("config reload failed, keeping previous configuration", zap.Error(err))
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.current
+	changed := diffFields(reflect.ValueOf(old), reflect.ValueOf(cfg), "")
+	w.current = cfg
+	subs := append([]func(ConfigChange[T]){}, w.subs...)
+	w.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	w.log.Info("config reloaded", zap.Strings("changed", changed))
+	change := ConfigChange[T]{Old: old, New: cfg, Changed: changed}
+	for _, fn := range subs {
+		fn(change)
+	}
+
+	return nil
+}
+
+// Watch polls the file sources every Interval, two seconds when not positive,
+// and listens for SIGHUP until ctx is done.
+func (w *ConfigWatcher[T]) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.log.Info("config reload requested by SIGHUP")
+			w.Reload()
+		case <-ticker.C:
+			mtimes := w.fileTimes()
+			if reflect.DeepEqual(mtimes, w.mtimes) {
+				continue
+			}
+			w.mtimes = mtimes
+			w.Reload()
+		}
+	}
+}
+
+func (w *ConfigWatcher[T]) fileTimes() map[string]time.Time {
+	mtimes := map[string]time.Time{}
+	for _, src := range w.loader.Sources {
+		path := sourcePath(src)
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			mtimes[path] = fi.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// diffFields lists the exported fields that differ, by dotted path. Structs
+// with unexported fields, like time.Time, are compared as a whole.
+func diffFields(old, cur reflect.Value, path string) []string {
+	if old.Kind() != reflect.Struct || (path != "" && !allExported(old.Type())) {
+		if reflect.DeepEqual(old.Interface(), cur.Interface()) {
+			return nil
+		}
+		return []string{path}
+	}
+
+	var changed []string
+	for i := 0; i < old.NumField(); i++ {
+		sf := old.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fieldPath := sf.Name
+		if path != "" {
+			fieldPath = path + "." + sf.Name
+		}
+		changed = append(changed, diffFields(old.Field(i), cur.Field(i), fieldPath)...)
+	}
+	return changed
+}
+
+func allExported(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -24,7 +24,7 @@ type DBParam struct {
 	Name     string
 	Schema   string
 	User     string
-	Password string
+	Password Secret
 	AppName  string
 	Timeout  int
 	MaxOpen  int
@@ -33,20 +33,35 @@ type DBParam struct {
 }
 
 type DBConfiguration struct {
-	Host           string
-	Port           string
-	Schema         string
-	DBName         string
-	Username       string
-	Password       string
-	Logging        bool
-	SessionName    string
-	ConnectTimeOut int
-	MaxOpenConn    int
-	MaxIdleConn    int
+	Host           string `env:"DB_HOST,required" validate:"required"`
+	Port           string `env:"DB_PORT" default:"5432" validate:"required,numeric,min=1,max=65535"`
+	Schema         string `env:"DB_SCHEMA"`
+	DBName         string `env:"DB_NAME,required" validate:"required"`
+	Username       string `env:"DB_USERNAME,required" validate:"required"`
+	Password       Secret `env:"DB_PASSWORD"`
+	Logging        bool   `env:"DB_LOGGING" default:"false"`
+	SessionName    string `env:"DB_SESSION_NAME"`
+	ConnectTimeOut int    `env:"DB_CONNECT_TIMEOUT" default:"10" validate:"min=0"`
+	MaxOpenConn    int    `env:"DB_MAX_OPEN_CONN" default:"10" validate:"min=0"`
+	MaxIdleConn    int    `env:"DB_MAX_IDLE_CONN" default:"5" validate:"min=0"`
+}
+
+func (c DBConfiguration) Validate() error {
+	if c.MaxOpenConn > 0 && c.MaxIdleConn > c.MaxOpenConn {
+		return FieldErrors{{
+			Field: "MaxIdleConn",
+			Key:   "DB_MAX_IDLE_CONN",
+			Err:   fmt.Errorf("must not exceed MaxOpenConn (%d), got %d", c.MaxOpenConn, c.MaxIdleConn),
+		}}
+	}
+	return nil
 }
 
 func NewPostgresConnection(config DBConfiguration, l *zap.Logger) (*gorm.DB, error) {
+	if err := ValidateStruct(config); err != nil {
+		return nil, errors.Wrap(err, "invalid db configuration")
+	}
+
 	dbCfg := DBParam{
 		Host:     config.Host,
 		Port:     config.Port,
@@ -95,6 +110,10 @@ func NewPostgresConnection(config DBConfiguration, l *zap.Logger) (*gorm.DB, err
 }
 
 func NewMySQLConnection(config DBConfiguration, l *zap.Logger) (*gorm.DB, error) {
+	if err := ValidateStruct(config); err != nil {
+		return nil, fmt.Errorf("invalid db configuration : %w", err)
+	}
+
 	dbCfg := DBParam{
 		Host:     config.Host,
 		Port:     config.Port,
@@ -124,7 +143,7 @@ func NewMySQLConnection(config DBConfiguration, l *zap.Logger) (*gorm.DB, error)
 	)
 
 	sql := makeMySQLString(dbCfg)
-	l.Info("sql string", zap.String("sql", sql))
+	l.Info("sql string", zap.String("sql", formatMySQLString(dbCfg, dbCfg.Password.String())))
 	db, err := gorm.Open(mysql.Open(sql), &gorm.Config{Logger: newLogger})
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect database : %w", err)
@@ -135,12 +154,16 @@ func NewMySQLConnection(config DBConfiguration, l *zap.Logger) (*gorm.DB, error)
 
 func makePostgresString(p DBParam) string {
 	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s connect_timeout=%d application_name=%s",
-		p.Host, p.Port, p.User, p.Name, p.Password, p.Timeout, p.AppName)
+		p.Host, p.Port, p.User, p.Name, p.Password.Reveal(), p.Timeout, p.AppName)
 }
 
 func makeMySQLString(p DBParam) string {
+	return formatMySQLString(p, p.Password.Reveal())
+}
+
+func formatMySQLString(p DBParam, password string) string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		p.User, p.Password, p.Host, p.Port, p.Name)
+		p.User, password, p.Host, p.Port, p.Name)
 }
 
 func MockGormDB(t *testing.T, doLog bool) (sqlmock.Sqlmock, *gorm.DB, error) {
@@ -0,0 +1,196 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func envError(name string, err error) error {
+	return fmt.Errorf("env %s : %w", name, err)
+}
+
+func lookupEnv(name string) (string, bool) {
+	val, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(val) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(val), true
+}
+
+func requireEnv(name string) (string, error) {
+	val, ok := lookupEnv(name)
+	if !ok {
+		return "", envError(name, ErrEnvMissing)
+	}
+	return val, nil
+}
+
+// EnvStringOr returns def when the variable is unset or blank.
+func EnvStringOr(envName, def string) string {
+	val, ok := lookupEnv(envName)
+	if !ok {
+		return def
+	}
+	return val
+}
+
+// EnvIntOr returns def when the variable is unset, and def with an error when
+// it is not an integer.
+func EnvIntOr(envName string, def int) (int, error) {
+	val, ok := lookupEnv(envName)
+	if !ok {
+		return def, nil
+	}
+	n, err := parseEnvInt(envName, val)
+	if err != nil {
+		return def, err
+	}
+	return n, nil
+}
+
+func parseEnvInt(envName, val string) (int, error) {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, envError(envName, fmt.Errorf("invalid integer %q", val))
+	}
+	return n, nil
+}
+
+// EnvBoolOr accepts the values understood by strconv.ParseBool.
+func EnvBoolOr(envName string, def bool) (bool, error) {
+	val, ok := lookupEnv(envName)
+	if !ok {
+		return def, nil
+	}
+	b, err := parseEnvBool(envName, val)
+	if err != nil {
+		return def, err
+	}
+	return b, nil
+}
+
+func parseEnvBool(envName, val string) (bool, error) {
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, envError(envName, fmt.Errorf("invalid boolean %q", val))
+	}
+	return b, nil
+}
+
+// EnvArraySep splits the variable on sep and trims every element. An unset
+// variable gives an empty slice.
+func EnvArraySep(envName, sep string) []string {
+	val, _ := lookupEnv(envName)
+	return splitEnvList(val, sep)
+}
+
+func EnvDuration(envName string) (time.Duration, error) {
+	val, err := requireEnv(envName)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, envError(envName, fmt.Errorf("invalid duration %q", val))
+	}
+	return d, nil
+}
+
+func EnvFloat(envName string) (float64, error) {
+	val, err := requireEnv(envName)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, envError(envName, fmt.Errorf("invalid number %q", val))
+	}
+	return f, nil
+}
+
+// EnvURL requires an absolute URL.
+func EnvURL(envName string) (*url.URL, error) {
+	val, err := requireEnv(envName)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(val)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, envError(envName, fmt.Errorf("invalid url %q", val))
+	}
+	return u, nil
+}
+
+// EnvEnum requires the variable to be one of allowed.
+func EnvEnum(envName string, allowed ...string) (string, error) {
+	val, err := requireEnv(envName)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range allowed {
+		if val == a {
+			return val, nil
+		}
+	}
+	return "", envError(envName, fmt.Errorf("%q is not one of [%s]", val, strings.Join(allowed, ", ")))
+}
+
+// EnvJSON unmarshals the variable into dst.
+func EnvJSON(envName string, dst interface{}) error {
+	val, err := requireEnv(envName)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(val), dst); err != nil {
+		return envError(envName, fmt.Errorf("invalid json (%w)", err))
+	}
+	return nil
+}
+
+func mustEnv[T any](val T, err error) T {
+	if err != nil {
+		panic(err.Error())
+	}
+	return val
+}
+
+func MustEnvString(envName string) string {
+	return mustEnv(requireEnv(envName))
+}
+
+func MustEnvInt(envName string) int {
+	return mustEnv(parseEnvInt(envName, mustEnv(requireEnv(envName))))
+}
+
+// MustEnvBool accepts the values understood by strconv.ParseBool, unlike
+// EnvBool.
+func MustEnvBool(envName string) bool {
+	return mustEnv(parseEnvBool(envName, mustEnv(requireEnv(envName))))
+}
+
+func MustEnvDuration(envName string) time.Duration {
+	return mustEnv(EnvDuration(envName))
+}
+
+func MustEnvFloat(envName string) float64 {
+	return mustEnv(EnvFloat(envName))
+}
+
+func MustEnvURL(envName string) *url.URL {
+	return mustEnv(EnvURL(envName))
+}
+
+func MustEnvEnum(envName string, allowed ...string) string {
+	return mustEnv(EnvEnum(envName, allowed...))
+}
+
+func MustEnvJSON(envName string, dst interface{}) {
+	if err := EnvJSON(envName, dst); err != nil {
+		panic(err.Error())
+	}
+}
@@ -0,0 +1,64 @@
+package library
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvAccessors(t *testing.T) {
+	t.Setenv("T_INT", " 42 ")
+	t.Setenv("T_BAD_INT", "4x2")
+	t.Setenv("T_BOOL", "1")
+	t.Setenv("T_DURATION", "1m30s")
+	t.Setenv("T_URL", "https://example.com/api")
+	t.Setenv("T_MODE", "prod")
+	t.Setenv("T_LIST", " a | b |c ")
+	t.Setenv("T_JSON", `{"a":1}`)
+
+	n, err := EnvIntOr("T_INT", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, n)
+
+	n, err = EnvIntOr("T_UNSET", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	_, err = EnvIntOr("T_BAD_INT", 7)
+	assert.EqualError(t, err, `env T_BAD_INT : invalid integer "4x2"`)
+	assert.Equal(t, 0, EnvInt("T_BAD_INT"))
+
+	assert.False(t, EnvBool("T_BOOL"))
+	assert.True(t, MustEnvBool("T_BOOL"))
+	t.Setenv("T_TRUE", "True")
+	assert.True(t, EnvBool("T_TRUE"))
+
+	d, err := EnvDuration("T_DURATION")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	_, err = EnvDuration("T_UNSET")
+	assert.True(t, errors.Is(err, ErrEnvMissing))
+
+	u, err := EnvURL("T_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", u.Host)
+
+	_, err = EnvEnum("T_MODE", "dev", "staging")
+	assert.Error(t, err)
+	assert.Equal(t, "prod", MustEnvEnum("T_MODE", "dev", "prod"))
+
+	assert.Equal(t, []string{"a", "b", "c"}, EnvArraySep("T_LIST", "|"))
+	assert.Empty(t, EnvArray("T_UNSET"))
+
+	var m map[string]int
+	assert.NoError(t, EnvJSON("T_JSON", &m))
+	assert.Equal(t, 1, m["a"])
+
+	assert.PanicsWithValue(t, "env T_UNSET : required variable is not set", func() {
+		MustEnvString("T_UNSET")
+	})
+	assert.Panics(t, func() { MustEnvInt("T_BAD_INT") })
+}
@@ -2,6 +2,8 @@ package library
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -18,14 +20,15 @@ import (
 )
 
 func EnvArray(envName string) []string {
-	val := os.Getenv(envName)
-	ar := strings.Split(val, ",")
-	return ar
+	return EnvArraySep(envName, ",")
 }
 func EnvInt(envName string) int {
-	val, _ := strconv.Atoi(os.Getenv(envName))
+	val, _ := EnvIntOr(envName, 0)
 	return val
 }
+
+// EnvBool is true only for "true" in any case, use EnvBoolOr for the other
+// values strconv.ParseBool accepts.
 func EnvBool(envName string) bool {
 	val := os.Getenv(envName)
 	return strings.ToUpper(val) == "TRUE"
@@ -65,13 +68,16 @@ func ToBytes(val interface{}) []byte {
 	return temp
 }
 
-func NewHTTPClient(rt http.RoundTripper, timeOut time.Duration) HttpClient {
+func NewHTTPClient(rt http.RoundTripper, timeOut time.Duration, opts ...HttpClientOption) HttpClient {
 	obj := HttpClient{
 		Client: &http.Client{
 			Transport: rt,
 			Timeout:   timeOut,
 		},
 	}
+	for _, opt := range opts {
+		opt(&obj)
+	}
 	return obj
 }
 
@@ -80,11 +86,15 @@ func StructToUrlValue(data interface{}) (url.Values, error) {
 }
 
 func (hc HttpClient) GET(header http.Header, url string) ([]byte, error) {
+	return hc.GETCtx(ctxB, header, url)
+}
+
+func (hc HttpClient) GETCtx(ctx context.Context, header http.Header, url string) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, err
 	}
 
-	load, err := request(hc.Client, url, "GET", header, nil)
+	load, err := hc.request(ctx, url, "GET", header, nil)
 	if err != nil {
 		return handleErr(err)
 	}
@@ -100,6 +110,14 @@ func HashPassword(password string) string {
 	bytes, _ := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes)
 }
+
+// tokenKey is a digest of token fit for key names, where the token itself
+// would show to anyone able to list keys.
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
 func VerifyPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
@@ -2,11 +2,13 @@ package library
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -42,7 +44,11 @@ type HTTPResponse struct {
 }
 
 type HttpClient struct {
-	Client *http.Client
+	Client  *http.Client
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+	Log     *zap.Logger
+	Timeout *HTTPTimeout
 }
 
 func GoodResponse(c *gin.Context, data interface{}) {
@@ -104,6 +110,10 @@ func ExtractBody(c *gin.Context) ([]byte, error) {
 }
 
 func (adaptor NetAdaptor) GET(log *zap.Logger, token, uri string, data interface{}) (HTTPResponse, error) {
+	return adaptor.GETCtx(ctxB, log, token, uri, data)
+}
+
+func (adaptor NetAdaptor) GETCtx(ctx context.Context, log *zap.Logger, token, uri string, data interface{}) (HTTPResponse, error) {
 	handleErr := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, fmt.Errorf("get %s  : %w", uri, err)
 	}
@@ -126,7 +136,7 @@ func (adaptor NetAdaptor) GET(log *zap.Logger, token, uri string, data interface
 		zap.String("method", "GET"),
 		zap.String("url", baseUrl.String()))
 
-	result, err := adaptor.Client.GET(makeHeaders(token), baseUrl.String())
+	result, err := adaptor.Client.GETCtx(ctx, makeHeaders(token), baseUrl.String())
 	if err != nil {
 		return handleErr(fmt.Errorf("http process (%w)", err))
 	}
@@ -140,6 +150,10 @@ func (adaptor NetAdaptor) GET(log *zap.Logger, token, uri string, data interface
 }
 
 func (adaptor NetAdaptor) POST(log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
+	return adaptor.POSTCtx(ctxB, log, token, url, data)
+}
+
+func (adaptor NetAdaptor) POSTCtx(ctx context.Context, log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
 	handleErr := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, fmt.Errorf("post %s  : %w", url, err)
 	}
@@ -154,7 +168,7 @@ func (adaptor NetAdaptor) POST(log *zap.Logger, token, url string, data interfac
 		zap.String("url", url),
 		zap.Any("data", data))
 
-	result, err := adaptor.Client.POST(makeHeaders(token), url, message)
+	result, err := adaptor.Client.POSTCtx(ctx, makeHeaders(token), url, message)
 	if err != nil {
 		return handleErr(fmt.Errorf("http process (%w)", err))
 	}
@@ -168,6 +182,10 @@ func (adaptor NetAdaptor) POST(log *zap.Logger, token, url string, data interfac
 }
 
 func (adaptor NetAdaptor) EXTPOST(log *zap.Logger, token, url string, data interface{}) ([]byte, error) {
+	return adaptor.EXTPOSTCtx(ctxB, log, token, url, data)
+}
+
+func (adaptor NetAdaptor) EXTPOSTCtx(ctx context.Context, log *zap.Logger, token, url string, data interface{}) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, fmt.Errorf("post %s  : %w", url, err)
 	}
@@ -182,9 +200,13 @@ func (adaptor NetAdaptor) EXTPOST(log *zap.Logger, token, url string, data inter
 		zap.String("url", url),
 		zap.Any("data", data))
 
-	return adaptor.Client.POST(makeHeaders(token), url, message)
+	return adaptor.Client.POSTCtx(ctx, makeHeaders(token), url, message)
 }
 func (adaptor NetAdaptor) PUT(log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
+	return adaptor.PUTCtx(ctxB, log, token, url, data)
+}
+
+func (adaptor NetAdaptor) PUTCtx(ctx context.Context, log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
 	handleErr := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, fmt.Errorf("put %s  : %w", url, err)
 	}
@@ -199,7 +221,7 @@ func (adaptor NetAdaptor) PUT(log *zap.Logger, token, url string, data interface
 		zap.String("url", url),
 		zap.Any("data", data))
 
-	result, err := adaptor.Client.PUT(makeHeaders(token), url, message)
+	result, err := adaptor.Client.PUTCtx(ctx, makeHeaders(token), url, message)
 	if err != nil {
 		return handleErr(fmt.Errorf("http process (%w)", err))
 	}
@@ -213,6 +235,10 @@ func (adaptor NetAdaptor) PUT(log *zap.Logger, token, url string, data interface
 }
 
 func (adaptor NetAdaptor) DELETE(log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
+	return adaptor.DELETECtx(ctxB, log, token, url, data)
+}
+
+func (adaptor NetAdaptor) DELETECtx(ctx context.Context, log *zap.Logger, token, url string, data interface{}) (HTTPResponse, error) {
 	handleErr := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, fmt.Errorf("delete %s  : %w", url, err)
 	}
@@ -227,7 +253,7 @@ func (adaptor NetAdaptor) DELETE(log *zap.Logger, token, url string, data interf
 		zap.String("url", url),
 		zap.Any("data", data))
 
-	result, err := adaptor.Client.DELETE(makeHeaders(token), url, message)
+	result, err := adaptor.Client.DELETECtx(ctx, makeHeaders(token), url, message)
 	if err != nil {
 		return handleErr(fmt.Errorf("http process (%w)", err))
 	}
@@ -241,11 +267,15 @@ func (adaptor NetAdaptor) DELETE(log *zap.Logger, token, url string, data interf
 }
 
 func (hc HttpClient) POST(header http.Header, url string, load []byte) ([]byte, error) {
+	return hc.POSTCtx(ctxB, header, url, load)
+}
+
+func (hc HttpClient) POSTCtx(ctx context.Context, header http.Header, url string, load []byte) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, err
 	}
 
-	load, err := request(hc.Client, url, "POST", header, load)
+	load, err := hc.request(ctx, url, "POST", header, load)
 	if err != nil {
 		return handleErr(err)
 	}
@@ -254,11 +284,15 @@ func (hc HttpClient) POST(header http.Header, url string, load []byte) ([]byte,
 }
 
 func (hc HttpClient) PUT(header http.Header, url string, load []byte) ([]byte, error) {
+	return hc.PUTCtx(ctxB, header, url, load)
+}
+
+func (hc HttpClient) PUTCtx(ctx context.Context, header http.Header, url string, load []byte) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, err
 	}
 
-	load, err := request(hc.Client, url, "PUT", header, load)
+	load, err := hc.request(ctx, url, "PUT", header, load)
 	if err != nil {
 		return handleErr(err)
 	}
@@ -267,11 +301,15 @@ func (hc HttpClient) PUT(header http.Header, url string, load []byte) ([]byte, e
 }
 
 func (hc HttpClient) DELETE(header http.Header, url string, load []byte) ([]byte, error) {
+	return hc.DELETECtx(ctxB, header, url, load)
+}
+
+func (hc HttpClient) DELETECtx(ctx context.Context, header http.Header, url string, load []byte) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, err
 	}
 
-	load, err := request(hc.Client, url, "DELETE", header, load)
+	load, err := hc.request(ctx, url, "DELETE", header, load)
 	if err != nil {
 		return handleErr(err)
 	}
@@ -279,33 +317,113 @@ func (hc HttpClient) DELETE(header http.Header, url string, load []byte) ([]byte
 	return load, nil
 }
 
-func request(client *http.Client, url, rtype string, headers http.Header, load []byte) ([]byte, error) {
+// request sends the request, again on failures the retry policy allows, and
+// returns the body of the last response.
+func (hc HttpClient) request(ctx context.Context, url, rtype string, headers http.Header, load []byte) ([]byte, error) {
 	handleErr := func(err error) ([]byte, error) {
 		return nil, fmt.Errorf("http call : %w", err)
 	}
 
-	reqBody := bytes.NewBuffer(load)
-
-	req, err := http.NewRequest(rtype, url, reqBody)
-	if err != nil {
-		return handleErr(fmt.Errorf("prepare request (%w)", err))
+	ctx = requestContext(ctx)
+	headers = outgoingHeaders(ctx, headers)
+	log := hc.logger()
+	if id := CorrelationID(ctx); id != "" {
+		log = log.With(zap.String("correlation_id", id))
+	}
+	attempts := hc.Retry.attempts()
+	if !hc.Retry.retryMethod(rtype) {
+		attempts = 1
 	}
 
-	req.Close = true
-	req.Header = headers
+	var last error
+	for attempt := 1; ; attempt++ {
+		// a fresh reader per attempt so the body is sent whole every time
+		req, err := http.NewRequestWithContext(ctx, rtype, url, bytes.NewReader(load))
+		if err != nil {
+			return handleErr(fmt.Errorf("prepare request (%w)", err))
+		}
+		req.Close = true
+		req.Header = headers
+
+		var generation uint64
+		if hc.Breaker != nil {
+			if generation, err = hc.Breaker.allow(req.URL.Host); err != nil {
+				// the circuit opened on our own failed attempts, their error
+				// says more than ErrCircuitOpen
+				if last != nil {
+					return handleErr(last)
+				}
+				return handleErr(err)
+			}
+		}
 
-	resp, err := client.Do(req)
+		start := time.Now()
+		response, status, header, err := hc.send(req)
+		log.Debug("http attempt",
+			zap.String("method", rtype),
+			zap.String("url", url),
+			zap.Int("attempt", attempt),
+			zap.Int("status", status),
+			zap.Duration("duration", time.Since(start)),
+			zap.Error(err))
+
+		retryable := true
+		if err == nil {
+			retryable = hc.Retry.retryStatus(status)
+			if retryable || status >= http.StatusInternalServerError {
+				err = &HTTPStatusError{Method: rtype, URL: url, StatusCode: status, Body: response}
+			}
+		}
+		if hc.Breaker != nil {
+			hc.Breaker.record(ctx, req.URL.Host, generation, err)
+		}
+		if err == nil {
+			return response, nil
+		}
+		if !retryable || attempt >= attempts || ctx.Err() != nil {
+			return handleErr(err)
+		}
+		last = err
+
+		wait := hc.Retry.delay(attempt)
+		if d, ok := retryAfter(header); ok {
+			if d > hc.Retry.maxDelay() {
+				return handleErr(fmt.Errorf("retry-after %s exceeds max delay (%w)", d, err))
+			}
+			wait = d
+		}
+		log.Warn("http retry",
+			zap.String("method", rtype),
+			zap.String("url", url),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return handleErr(ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (hc HttpClient) send(req *http.Request) ([]byte, int, http.Header, error) {
+	if d := hc.Timeout.Get(); d > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	resp, err := hc.Client.Do(req)
 	if err != nil {
-		return handleErr(fmt.Errorf("do request (%w)", err))
+		return nil, 0, nil, fmt.Errorf("do request (%w)", err)
 	}
 
 	defer resp.Body.Close()
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return handleErr(fmt.Errorf("read response (%w)", err))
+		return nil, resp.StatusCode, resp.Header, fmt.Errorf("read response (%w)", err)
 	}
-
-	return response, nil
+	return response, resp.StatusCode, resp.Header, nil
 }
 
 func makeHeaders(token string) http.Header {
@@ -316,26 +434,33 @@ func makeHeaders(token string) http.Header {
 }
 
 func HTTPRequest(reqType string, headers http.Header, url string, load []byte) ([]byte, error) {
+	return HTTPRequestCtx(ctxB, reqType, headers, url, load)
+}
+
+// HTTPRequestCtx sends one request that is canceled with ctx and carries its
+// correlation id.
+func HTTPRequestCtx(ctx context.Context, reqType string, headers http.Header, url string, load []byte) ([]byte, error) {
 	errHandle := func(err error) ([]byte, error) {
 		return nil, err
 	}
 
+	ctx = requestContext(ctx)
 	var request *http.Request
 	var err error
 
 	if load == nil {
-		request, err = http.NewRequest(reqType, url, nil)
+		request, err = http.NewRequestWithContext(ctx, reqType, url, nil)
 		if err != nil {
 			return errHandle(err)
 		}
 	} else {
-		request, err = http.NewRequest(reqType, url, bytes.NewBuffer(load))
+		request, err = http.NewRequestWithContext(ctx, reqType, url, bytes.NewBuffer(load))
 		if err != nil {
 			return errHandle(err)
 		}
 	}
 
-	request.Header = headers
+	request.Header = outgoingHeaders(ctx, headers)
 	client := &http.Client{}
 	resp, err := client.Do(request)
 	if err != nil {
@@ -353,11 +478,15 @@ func HTTPRequest(reqType string, headers http.Header, url string, load []byte) (
 }
 
 func HttpGet(net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
+	return HttpGetCtx(ctxB, net, log, url, token, input)
+}
+
+func HttpGetCtx(ctx context.Context, net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
 	errHandle := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, err
 	}
 
-	result, err := net.GET(log, token, url, input)
+	result, err := net.GETCtx(ctx, log, token, url, input)
 	if err != nil {
 		return errHandle(fmt.Errorf("request to service: %w", err))
 	}
@@ -369,11 +498,15 @@ func HttpGet(net NetAdaptor, log *zap.Logger, url, token string, input interface
 }
 
 func HttpPost(net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
+	return HttpPostCtx(ctxB, net, log, url, token, input)
+}
+
+func HttpPostCtx(ctx context.Context, net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
 	errHandle := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, err
 	}
 
-	result, err := net.POST(log, token, url, input)
+	result, err := net.POSTCtx(ctx, log, token, url, input)
 	if err != nil {
 		return errHandle(fmt.Errorf("request to service: %w", err))
 	}
@@ -385,11 +518,15 @@ func HttpPost(net NetAdaptor, log *zap.Logger, url, token string, input interfac
 }
 
 func HttpPut(net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
+	return HttpPutCtx(ctxB, net, log, url, token, input)
+}
+
+func HttpPutCtx(ctx context.Context, net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
 	errHandle := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, err
 	}
 
-	result, err := net.PUT(log, token, url, input)
+	result, err := net.PUTCtx(ctx, log, token, url, input)
 	if err != nil {
 		return errHandle(fmt.Errorf("request to service: %w", err))
 	}
@@ -401,11 +538,15 @@ func HttpPut(net NetAdaptor, log *zap.Logger, url, token string, input interface
 }
 
 func HttpDelete(net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
+	return HttpDeleteCtx(ctxB, net, log, url, token, input)
+}
+
+func HttpDeleteCtx(ctx context.Context, net NetAdaptor, log *zap.Logger, url, token string, input interface{}) (HTTPResponse, error) {
 	errHandle := func(err error) (HTTPResponse, error) {
 		return HTTPResponse{}, err
 	}
 
-	result, err := net.DELETE(log, token, url, input)
+	result, err := net.DELETECtx(ctx, log, token, url, input)
 	if err != nil {
 		return errHandle(fmt.Errorf("request to service: %w", err))
 	}
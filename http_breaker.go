@@ -0,0 +1,226 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+type BreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row. 5 when zero.
+	ConsecutiveFailures int
+	// FailureRatio opens the circuit when that share of the requests in the
+	// current Window failed, once MinRequests were made. Zero disables it.
+	FailureRatio float64
+	MinRequests  int
+	// Window is how long the closed state counts requests before starting
+	// over. One minute when zero.
+	Window time.Duration
+	// OpenTimeout is how long the circuit stays open before letting trial
+	// requests through. 30 seconds when zero.
+	OpenTimeout time.Duration
+	// HalfOpenRequests trial requests must all succeed to close the circuit
+	// again. One when zero.
+	HalfOpenRequests int
+}
+
+type hostCircuit struct {
+	state       CircuitState
+	generation  uint64
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	inFlight    int
+	successes   int
+}
+
+// CircuitBreaker tracks every upstream host separately, so one failing
+// service does not stop calls to the others.
+type CircuitBreaker struct {
+	cfg   BreakerConfig
+	log   *zap.Logger
+	mu    sync.Mutex
+	hosts map[string]*hostCircuit
+}
+
+func NewCircuitBreaker(cfg BreakerConfig, log *zap.Logger) *CircuitBreaker {
+	if cfg.ConsecutiveFailures == 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.Window == 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests == 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return &CircuitBreaker{cfg: cfg, log: log, hosts: map[string]*hostCircuit{}}
+}
+
+// State is the circuit of host as of now.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.circuit(host, time.Now()).state
+}
+
+// circuit returns the circuit of host after the time based transitions.
+func (b *CircuitBreaker) circuit(host string, now time.Time) *hostCircuit {
+	hc, ok := b.hosts[host]
+	if !ok {
+		hc = &hostCircuit{windowStart: now}
+		b.hosts[host] = hc
+	}
+	switch {
+	case hc.state == CircuitOpen && now.Sub(hc.openedAt) >= b.cfg.OpenTimeout:
+		b.setState(host, hc, CircuitHalfOpen, now)
+	case hc.state == CircuitClosed && now.Sub(hc.windowStart) >= b.cfg.Window:
+		hc.requests, hc.failures, hc.windowStart = 0, 0, now
+	}
+	return hc
+}
+
+func (b *CircuitBreaker) setState(host string, hc *hostCircuit, state CircuitState, now time.Time) {
+	logf := b.log.Info
+	if state == CircuitOpen {
+		logf = b.log.Warn
+	}
+	logf("circuit breaker state change",
+		zap.String("host", host),
+		zap.Stringer("from", hc.state),
+		zap.Stringer("to", state),
+		zap.Int("consecutive_failures", hc.consecutive),
+		zap.Int("failures", hc.failures),
+		zap.Int("requests", hc.requests))
+
+	hc.state = state
+	hc.generation++
+	hc.consecutive, hc.requests, hc.failures = 0, 0, 0
+	hc.inFlight, hc.successes = 0, 0
+	hc.windowStart = now
+	if state == CircuitOpen {
+		hc.openedAt = now
+	}
+}
+
+// allow reports whether a request to host may go out, and the generation to
+// pass to record with its outcome.
+func (b *CircuitBreaker) allow(host string) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hc := b.circuit(host, time.Now())
+	switch hc.state {
+	case CircuitOpen:
+		return 0, fmt.Errorf("%s : %w", host, ErrCircuitOpen)
+	case CircuitHalfOpen:
+		if hc.inFlight >= b.cfg.HalfOpenRequests {
+			return 0, fmt.Errorf("%s : %w", host, ErrCircuitOpen)
+		}
+		hc.inFlight++
+	}
+	return hc.generation, nil
+}
+
+// record counts the outcome of a request allowed in generation. Outcomes of
+// requests that started before the last state change are ignored, and so are
+// requests the caller canceled. A deadline running out counts as a failure,
+// as a hung host is what the breaker is for.
+func (b *CircuitBreaker) record(ctx context.Context, host string, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	hc := b.circuit(host, now)
+	if hc.generation != generation {
+		return
+	}
+
+	if hc.state == CircuitHalfOpen {
+		hc.inFlight--
+	}
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	success := !breakerFailure(err)
+
+	if hc.state == CircuitHalfOpen {
+		if !success {
+			b.setState(host, hc, CircuitOpen, now)
+			return
+		}
+		if hc.successes++; hc.successes >= b.cfg.HalfOpenRequests {
+			b.setState(host, hc, CircuitClosed, now)
+		}
+		return
+	}
+
+	hc.requests++
+	if success {
+		hc.consecutive = 0
+		return
+	}
+	hc.failures++
+	hc.consecutive++
+
+	ratioTripped := b.cfg.FailureRatio > 0 && hc.requests >= b.cfg.MinRequests &&
+		float64(hc.failures)/float64(hc.requests) >= b.cfg.FailureRatio
+	if hc.consecutive >= b.cfg.ConsecutiveFailures || ratioTripped {
+		b.setState(host, hc, CircuitOpen, now)
+	}
+}
+
+// breakerFailure is what the breaker counts against a host: transport errors
+// and server errors, not 4xx answers.
+func breakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se *HTTPStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500
+	}
+	return true
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen while the
+// breaker holds the circuit of the target host open.
+func WithCircuitBreaker(b *CircuitBreaker) HttpClientOption {
+	return func(hc *HttpClient) {
+		hc.Breaker = b
+	}
+}
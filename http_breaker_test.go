@@ -0,0 +1,182 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHttpClientCircuitBreaker(t *testing.T) {
+	var healthy, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":true}`))
+	}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":true}`))
+	}))
+	defer other.Close()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	breaker := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: 50 * time.Millisecond}, zap.New(core))
+	hc := NewHTTPClient(http.DefaultTransport, time.Second, WithCircuitBreaker(breaker))
+	host := mustHost(t, srv.URL)
+
+	for i := 0; i < 3; i++ {
+		_, err := hc.GET(http.Header{}, srv.URL)
+		assert.False(t, errors.Is(err, ErrCircuitOpen))
+	}
+	assert.Equal(t, CircuitOpen, breaker.State(host))
+
+	// open: fail fast without calling the host
+	_, err := hc.GET(http.Header{}, srv.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// other hosts are unaffected
+	_, err = hc.GET(http.Header{}, other.URL)
+	assert.NoError(t, err)
+
+	// half-open: a failed trial opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State(host))
+	_, err = hc.GET(http.Header{}, srv.URL)
+	assert.False(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, CircuitOpen, breaker.State(host))
+
+	// and a successful one closes it
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	_, err = hc.GET(http.Header{}, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, breaker.State(host))
+
+	var states []string
+	for _, e := range logs.FilterMessage("circuit breaker state change").All() {
+		states = append(states, e.ContextMap()["to"].(string))
+	}
+	assert.Equal(t, []string{"open", "half-open", "open", "half-open", "closed"}, states)
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 100, FailureRatio: 0.5, MinRequests: 4}, nil)
+	fail := errors.New("connection refused")
+
+	outcomes := []error{nil, fail, nil, fail}
+	for i, outcome := range outcomes {
+		gen, err := b.allow("api")
+		assert.NoError(t, err)
+		b.record(ctxB, "api", gen, outcome)
+		if i < len(outcomes)-1 {
+			assert.Equal(t, CircuitClosed, b.State("api"))
+		}
+	}
+	assert.Equal(t, CircuitOpen, b.State("api"))
+}
+
+func TestCircuitBreakerIgnores(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Millisecond}, nil)
+
+	// 4xx answers and canceled calls are not the host's fault
+	gen, _ := b.allow("api")
+	b.record(ctxB, "api", gen, &HTTPStatusError{StatusCode: http.StatusTooManyRequests})
+	canceled, cancel := context.WithCancel(ctxB)
+	cancel()
+	gen, _ = b.allow("api")
+	b.record(canceled, "api", gen, context.Canceled)
+	assert.Equal(t, CircuitClosed, b.State("api"))
+
+	// a late result from before the circuit opened is ignored
+	stale, _ := b.allow("api")
+	gen, _ = b.allow("api")
+	b.record(ctxB, "api", gen, errors.New("timeout"))
+	time.Sleep(2 * time.Millisecond)
+	trial, err := b.allow("api")
+	assert.NoError(t, err)
+	_, err = b.allow("api")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	b.record(ctxB, "api", stale, nil)
+	assert.Equal(t, CircuitHalfOpen, b.State("api"))
+	b.record(ctxB, "api", trial, nil)
+	assert.Equal(t, CircuitClosed, b.State("api"))
+}
+
+func TestHttpClientCircuitBreakerTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	host := mustHost(t, srv.URL)
+
+	// the caller hanging up is not the host's fault
+	breaker := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 1}, nil)
+	hc := NewHTTPClient(http.DefaultTransport, time.Second, WithCircuitBreaker(breaker))
+	ctx, cancel := context.WithCancel(ctxB)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := hc.GETCtx(ctx, http.Header{}, srv.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, CircuitClosed, breaker.State(host))
+
+	// a request deadline running out is
+	ctx, cancel = context.WithTimeout(ctxB, 20*time.Millisecond)
+	defer cancel()
+	_, err = hc.GETCtx(ctx, http.Header{}, srv.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, CircuitOpen, breaker.State(host))
+
+	// and so is the client timing out
+	breaker = NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 1}, nil)
+	hc = NewHTTPClient(http.DefaultTransport, 20*time.Millisecond, WithCircuitBreaker(breaker))
+	_, err = hc.GET(http.Header{}, srv.URL)
+	assert.Error(t, err)
+	assert.Equal(t, CircuitOpen, breaker.State(host))
+}
+
+func TestHttpClientCircuitOpensDuringRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	breaker := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 2}, nil)
+	hc := NewHTTPClient(http.DefaultTransport, time.Second, WithCircuitBreaker(breaker), WithRetryPolicy(testRetryPolicy()))
+
+	// the third attempt is refused, the caller sees the upstream answer
+	_, err := hc.GET(http.Header{}, srv.URL)
+	var se *HTTPStatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, http.StatusServiceUnavailable, se.StatusCode)
+	assert.False(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	_, err = hc.GET(http.Header{}, srv.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func mustHost(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	assert.NoError(t, err)
+	return u.Host
+}
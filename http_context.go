@@ -0,0 +1,101 @@
+package library
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CorrelationIDHeader = "X-Correlation-ID"
+	// correlationIDGinKey holds the id in the gin context, next to "token".
+	correlationIDGinKey = "correlation_id"
+)
+
+type correlationIDKey struct{}
+
+type outgoingHeadersKey struct{}
+
+// WithCorrelationID returns a context whose outgoing requests carry id in the
+// X-Correlation-ID header.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the id set by WithCorrelationID or by
+// CorrelationMiddleware.
+func CorrelationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey{}).(string); ok {
+		return id
+	}
+	if id, ok := ctx.Value(correlationIDGinKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// WithOutgoingHeaders returns a context whose outgoing requests carry h, for
+// other request-scoped values to forward downstream. Headers given to the
+// call itself win over these.
+func WithOutgoingHeaders(ctx context.Context, h http.Header) context.Context {
+	merged := http.Header{}
+	if prev, ok := ctx.Value(outgoingHeadersKey{}).(http.Header); ok {
+		for k, v := range prev {
+			merged[k] = v
+		}
+	}
+	for k, v := range h {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingHeadersKey{}, merged)
+}
+
+// requestContext replaces a *gin.Context by the context of its request, which
+// carries the correlation id and is canceled with the request. Values wrapped
+// around a *gin.Context are out of reach, wrap c.Request.Context() instead.
+func requestContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		return c.Request.Context()
+	}
+	return ctx
+}
+
+// outgoingHeaders adds the context's headers to a copy of headers.
+func outgoingHeaders(ctx context.Context, headers http.Header) http.Header {
+	extra, _ := ctx.Value(outgoingHeadersKey{}).(http.Header)
+	id := CorrelationID(ctx)
+	if len(extra) == 0 && id == "" {
+		return headers
+	}
+
+	h := headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	for k, v := range extra {
+		if _, ok := h[k]; !ok {
+			h[k] = v
+		}
+	}
+	if id != "" && h.Get(CorrelationIDHeader) == "" {
+		h.Set(CorrelationIDHeader, id)
+	}
+	return h
+}
+
+// CorrelationMiddleware takes the correlation id from the request header, or
+// makes one, echoes it on the response and puts it in the request context so
+// calls made with c.Request.Context() forward it.
+func CorrelationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(CorrelationIDHeader)
+		if id == "" {
+			id = UUID()
+		}
+		c.Set(correlationIDGinKey, id)
+		c.Header(CorrelationIDHeader, id)
+		c.Request = c.Request.WithContext(WithCorrelationID(c.Request.Context(), id))
+		c.Next()
+	}
+}
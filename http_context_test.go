@@ -0,0 +1,149 @@
+package library
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestHttpContextPropagation(t *testing.T) {
+	seen := make(chan http.Header, 10)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+		w.Write([]byte(`{"status":true}`))
+	}))
+	defer downstream.Close()
+
+	net := NetAdaptor{Client: NewHTTPClient(http.DefaultTransport, time.Second)}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CorrelationMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		ctx := WithOutgoingHeaders(c.Request.Context(), http.Header{"X-Tenant": {"acme"}})
+		res, err := net.GETCtx(ctx, zap.NewNop(), "token", downstream.URL, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, CorrelationID(c), CorrelationID(c.Request.Context()))
+		GoodResponse(c, res.Status)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CorrelationIDHeader, "corr-1")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "corr-1", w.Header().Get(CorrelationIDHeader))
+	h := <-seen
+	assert.Equal(t, "corr-1", h.Get(CorrelationIDHeader))
+	assert.Equal(t, "acme", h.Get("X-Tenant"))
+	assert.Equal(t, "token", h.Get("Authorization"))
+
+	// an id is made up when the caller sends none
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotEmpty(t, w.Header().Get(CorrelationIDHeader))
+	assert.Equal(t, w.Header().Get(CorrelationIDHeader), (<-seen).Get(CorrelationIDHeader))
+
+	// explicit headers win over the context
+	ctx := WithCorrelationID(context.Background(), "corr-2")
+	_, err := HTTPRequestCtx(ctx, http.MethodGet, http.Header{CorrelationIDHeader: {"explicit"}}, downstream.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "explicit", (<-seen).Get(CorrelationIDHeader))
+	_, err = HTTPRequestCtx(ctx, http.MethodGet, nil, downstream.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "corr-2", (<-seen).Get(CorrelationIDHeader))
+}
+
+func TestHttpContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	hc := NewHTTPClient(http.DefaultTransport, time.Minute, WithRetryPolicy(testRetryPolicy()))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NetAdaptor{Client: hc}.POSTCtx(ctx, zap.NewNop(), "", slow.URL, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = HTTPRequestCtx(ctx, http.MethodGet, nil, slow.URL, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHttpContextGinContext(t *testing.T) {
+	seen := make(chan string, 1)
+	release := make(chan struct{})
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get(CorrelationIDHeader)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer downstream.Close()
+	defer close(release)
+
+	hc := NewHTTPClient(http.DefaultTransport, time.Minute)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CorrelationMiddleware())
+	errs := make(chan error, 1)
+	r.GET("/", func(c *gin.Context) {
+		// the gin context itself is passed, its request is what counts
+		_, err := hc.GETCtx(c, http.Header{}, downstream.URL)
+		errs <- err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	req.Header.Set(CorrelationIDHeader, "corr-3")
+	go r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "corr-3", <-seen)
+	cancel()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("call not canceled with the request")
+	}
+}
+
+func TestHttpHelpersCtx(t *testing.T) {
+	seen := make(chan string, 4)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Method + " " + r.Header.Get(CorrelationIDHeader)
+		w.Write([]byte(`{"status":true}`))
+	}))
+	defer downstream.Close()
+
+	net := NetAdaptor{Client: NewHTTPClient(http.DefaultTransport, time.Second)}
+	ctx := WithCorrelationID(context.Background(), "corr-4")
+	helpers := map[string]func(context.Context, NetAdaptor, *zap.Logger, string, string, interface{}) (HTTPResponse, error){
+		http.MethodGet:    HttpGetCtx,
+		http.MethodPost:   HttpPostCtx,
+		http.MethodPut:    HttpPutCtx,
+		http.MethodDelete: HttpDeleteCtx,
+	}
+	for method, helper := range helpers {
+		res, err := helper(ctx, net, zap.NewNop(), downstream.URL, "token", nil)
+		assert.NoError(t, err, method)
+		assert.True(t, res.Status, method)
+		assert.Equal(t, method+" corr-4", <-seen)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = helper(canceled, net, zap.NewNop(), downstream.URL, "token", nil)
+		assert.ErrorIs(t, err, context.Canceled, method)
+	}
+}
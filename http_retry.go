@@ -0,0 +1,191 @@
+package library
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetryPolicy decides which failed requests HttpClient sends again. The zero
+// value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too.
+	MaxAttempts int
+	// BaseDelay doubles after every attempt up to MaxDelay, which is a minute
+	// when zero. A Retry-After longer than MaxDelay ends the retries.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of each delay that is randomized, from 0 to 1.
+	Jitter float64
+	// RetryMethods are the methods safe to send twice, the idempotent ones
+	// when nil. Add POST only for endpoints protected by an idempotency key.
+	RetryMethods []string
+	// RetryStatuses are retried like network errors, 429, 502, 503 and 504
+	// when nil.
+	RetryStatuses []int
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+const defaultMaxRetryDelay = time.Minute
+
+var (
+	defaultRetryMethods  = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+	defaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) retryMethod(method string) bool {
+	methods := p.RetryMethods
+	if methods == nil {
+		methods = defaultRetryMethods
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) retryStatus(status int) bool {
+	statuses := p.RetryStatuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return defaultMaxRetryDelay
+	}
+	return p.MaxDelay
+}
+
+// delay is the wait before attempt+1, with the jitter taken off the
+// exponential value so MaxDelay is never exceeded.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	max := p.maxDelay()
+	d := p.BaseDelay
+	for i := 1; i < attempt && d > 0 && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d < 0 {
+		d = 0
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	val := h.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// HTTPStatusError is returned for a 5xx response, or for a retryable status
+// still failing after the last attempt. Body is the last response body.
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s %s : status %d", e.Method, e.URL, e.StatusCode)
+}
+
+type HttpClientOption func(*HttpClient)
+
+func WithRetryPolicy(p RetryPolicy) HttpClientOption {
+	return func(hc *HttpClient) {
+		hc.Retry = p
+	}
+}
+
+// WithHTTPLogger logs every attempt at debug level and retries at warn.
+func WithHTTPLogger(log *zap.Logger) HttpClientOption {
+	return func(hc *HttpClient) {
+		hc.Log = log
+	}
+}
+
+// HTTPTimeout bounds every attempt of an HttpClient and, unlike
+// http.Client.Timeout, can be changed while requests are in flight, for
+// example from a ConfigWatcher subscriber.
+type HTTPTimeout struct {
+	nanos int64
+}
+
+func NewHTTPTimeout(d time.Duration) *HTTPTimeout {
+	return &HTTPTimeout{nanos: int64(d)}
+}
+
+// Set applies to the attempts started afterwards. Zero means no timeout.
+func (t *HTTPTimeout) Set(d time.Duration) {
+	atomic.StoreInt64(&t.nanos, int64(d))
+}
+
+func (t *HTTPTimeout) Get() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&t.nanos))
+}
+
+// WithHTTPTimeout bounds each attempt by t. Give NewHTTPClient a zero timeOut
+// so the fixed timeout of the http.Client does not cut in first.
+func WithHTTPTimeout(t *HTTPTimeout) HttpClientOption {
+	return func(hc *HttpClient) {
+		hc.Timeout = t
+	}
+}
+
+func (hc HttpClient) logger() *zap.Logger {
+	if hc.Log == nil {
+		return zap.NewNop()
+	}
+	return hc.Log
+}
@@ -0,0 +1,176 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Jitter: 0.5}
+}
+
+func TestHttpClientRetry(t *testing.T) {
+	var calls int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":true,"data":"ok"}`))
+	}))
+	defer srv.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	hc := NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(testRetryPolicy()), WithHTTPLogger(zap.New(core)))
+
+	res, err := NetAdaptor{Client: hc}.PUT(zap.NewNop(), "token", srv.URL, map[string]int{"id": 1})
+	assert.NoError(t, err)
+	assert.True(t, res.Status)
+	assert.Equal(t, int32(3), calls)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":1}`, `{"id":1}`}, bodies)
+	assert.Equal(t, 3, logs.FilterMessage("http attempt").Len())
+	assert.Equal(t, 2, logs.FilterMessage("http retry").Len())
+}
+
+func TestHttpClientRetryExhausted(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream down"))
+	}))
+	defer srv.Close()
+
+	hc := NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(testRetryPolicy()))
+	_, err := hc.GET(http.Header{}, srv.URL)
+	var se *HTTPStatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, http.StatusBadGateway, se.StatusCode)
+	assert.Equal(t, "upstream down", string(se.Body))
+	assert.Equal(t, int32(3), calls)
+
+	// POST is not idempotent, so it is not retried by default
+	atomic.StoreInt32(&calls, 0)
+	_, err = hc.POST(http.Header{}, srv.URL, []byte("{}"))
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls)
+
+	// unless the policy says so
+	p := testRetryPolicy()
+	p.RetryMethods = []string{http.MethodPost}
+	atomic.StoreInt32(&calls, 0)
+	_, err = NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(p)).POST(http.Header{}, srv.URL, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), calls)
+}
+
+func TestHttpClientStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/bad", func(c *gin.Context) {
+		BadResponse(zap.NewNop(), c, RespParams{ErrorCode: "INVALID", Description: "bad input"})
+	})
+	r.POST("/crash", func(c *gin.Context) { c.String(http.StatusInternalServerError, "boom") })
+	r.POST("/slow", func(c *gin.Context) {
+		c.Header("Retry-After", "120")
+		c.Status(http.StatusTooManyRequests)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	net := NetAdaptor{Client: NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(testRetryPolicy()))}
+
+	// a 400 envelope is an answer, not a failure
+	res, err := net.POST(zap.NewNop(), "", srv.URL+"/bad", nil)
+	assert.NoError(t, err)
+	assert.False(t, res.Status)
+	assert.Equal(t, "INVALID", res.ErrorCode)
+
+	_, err = net.POST(zap.NewNop(), "", srv.URL+"/crash", nil)
+	var se *HTTPStatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, http.StatusInternalServerError, se.StatusCode)
+
+	// a Retry-After beyond MaxDelay stops retrying at once
+	p := testRetryPolicy()
+	p.RetryMethods = []string{http.MethodPost}
+	start := time.Now()
+	_, err = NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(p)).POST(http.Header{}, srv.URL+"/slow", nil)
+	assert.ErrorContains(t, err, "exceeds max delay")
+	assert.Less(t, time.Since(start), time.Second)
+
+	// without MaxDelay a Retry-After is still capped
+	p.MaxDelay = 0
+	start = time.Now()
+	_, err = NewHTTPClient(http.DefaultTransport, time.Second, WithRetryPolicy(p)).POST(http.Header{}, srv.URL+"/slow", nil)
+	assert.ErrorContains(t, err, "exceeds max delay")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestHttpClientTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer slow.Close()
+
+	timeout := NewHTTPTimeout(20 * time.Millisecond)
+	hc := NewHTTPClient(http.DefaultTransport, 0, WithHTTPTimeout(timeout))
+	_, err := hc.GET(http.Header{}, slow.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// changed while other requests run, it applies to the next ones
+	done := make(chan struct{})
+	go func() {
+		hc.GET(http.Header{}, slow.URL)
+		close(done)
+	}()
+	timeout.Set(time.Second)
+	<-done
+	body, err := hc.GET(http.Header{}, slow.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.delay(1))
+	assert.Equal(t, 400*time.Millisecond, p.delay(3))
+	assert.Equal(t, time.Second, p.delay(10))
+	assert.Equal(t, time.Second, p.delay(100))
+	assert.Equal(t, defaultMaxRetryDelay, RetryPolicy{BaseDelay: time.Second}.delay(100))
+	assert.Equal(t, 1, p.attempts())
+
+	p.Jitter = 1
+	for i := 0; i < 20; i++ {
+		assert.LessOrEqual(t, p.delay(2), 200*time.Millisecond)
+	}
+
+	d, ok := retryAfter(http.Header{"Retry-After": {"3"}})
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+	d, ok = retryAfter(http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}})
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, d, float64(2*time.Second))
+	_, ok = retryAfter(http.Header{"Retry-After": {"soon"}})
+	assert.False(t, ok)
+}
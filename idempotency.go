@@ -0,0 +1,217 @@
+package library
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+type IdempotencyConfig struct {
+	// Header carrying the key, Idempotency-Key when empty.
+	Header string
+	// Required rejects requests without a key instead of passing them through.
+	Required bool
+	// LockTTL bounds how long a crashed instance keeps a key locked, the lock
+	// is renewed while the request runs. One minute when zero.
+	LockTTL time.Duration
+	// ResponseTTL is how long a response is replayed. 24 hours when zero.
+	ResponseTTL time.Duration
+	// Scope separates the keys of different callers, for example by user id.
+	// When nil keys are scoped by the "token" set in the gin context, or else
+	// by the Authorization header, so no caller is replayed another's response.
+	Scope func(c *gin.Context) string
+	// FailOpen lets requests through without idempotency while the cache is
+	// unavailable. They get 503 otherwise.
+	FailOpen bool
+	// MaxBodyBytes limits the body read for the fingerprint, larger requests
+	// get 413. One megabyte when zero.
+	MaxBodyBytes int64
+}
+
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// idempotencyRecorder keeps a copy of what the handler writes.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware replays the stored response of a request whose
+// Idempotency-Key was seen before. While the first request with a key runs,
+// duplicates get 409; reusing a key for a different method, URI or body
+// gets 422. Server errors are not stored so the client can retry them.
+func IdempotencyMiddleware(cache *RedisCache, cfg IdempotencyConfig, log *zap.Logger) gin.HandlerFunc {
+	if cfg.Header == "" {
+		cfg.Header = IdempotencyKeyHeader
+	}
+	if cfg.LockTTL == 0 {
+		cfg.LockTTL = time.Minute
+	}
+	if cfg.ResponseTTL == 0 {
+		cfg.ResponseTTL = 24 * time.Hour
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.Scope == nil {
+		cfg.Scope = idempotencyCaller
+	}
+	responses := NewTypedCache[idempotentResponse](cache, nil)
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(cfg.Header)
+		if key == "" {
+			if cfg.Required {
+				abortIdempotency(c, http.StatusBadRequest, "IDEMPOTENCY_KEY_REQUIRED", cfg.Header+" header is required")
+				return
+			}
+			c.Next()
+			return
+		}
+		if scope := cfg.Scope(c); scope != "" {
+			key = scope + ":" + key
+		}
+		name := "idempotency:" + key
+
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBodyBytes)
+		}
+		body, err := ExtractBody(c)
+		if err != nil {
+			if bodyTooLarge(err) {
+				abortIdempotency(c, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", "request body is too large")
+				return
+			}
+			abortIdempotency(c, http.StatusBadRequest, "INVALID_BODY", "cannot read request body")
+			return
+		}
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.RequestURI()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := c.Request.Context()
+		replay := func() bool {
+			stored, err := responses.GetCtx(ctx, name)
+			if errors.Is(err, ErrCacheMiss) {
+				return false
+			}
+			if err != nil {
+				log.Warn("load idempotent response",
+					zap.String("connection", c.Request.URL.Path),
+					zap.Error(err))
+				return false
+			}
+			if stored.Fingerprint != fingerprint {
+				abortIdempotency(c, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED",
+					"idempotency key was used for a different request")
+				return true
+			}
+			for k, vals := range stored.Header {
+				c.Writer.Header()[k] = vals
+			}
+			c.Header(IdempotencyReplayedHeader, "true")
+			c.Data(stored.Status, stored.Header.Get("Content-Type"), stored.Body)
+			c.Abort()
+			return true
+		}
+		if replay() {
+			return
+		}
+
+		lock, err := cache.Lock(ctx, name+":lock", cfg.LockTTL)
+		if errors.Is(err, ErrLockNotObtained) {
+			abortIdempotency(c, http.StatusConflict, "IDEMPOTENCY_IN_PROGRESS",
+				"a request with this idempotency key is in progress")
+			return
+		}
+		if err != nil {
+			log.Warn("idempotency lock",
+				zap.String("connection", c.Request.URL.Path),
+				zap.Error(err))
+			if cfg.FailOpen {
+				c.Next()
+				return
+			}
+			abortIdempotency(c, http.StatusServiceUnavailable, "IDEMPOTENCY_UNAVAILABLE",
+				"idempotency keys cannot be checked, try again later")
+			return
+		}
+		defer lock.Unlock(ctxB)
+		lock.AutoRenew(ctx)
+
+		// the first request may have finished between the lookup and the lock
+		if replay() {
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+
+		if rec.Status() >= http.StatusInternalServerError {
+			return
+		}
+		err = responses.SetCtx(ctxB, name, idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      rec.Status(),
+			Header:      rec.Header().Clone(),
+			Body:        rec.body.Bytes(),
+		}, cfg.ResponseTTL)
+		if err != nil {
+			log.Error("store idempotent response",
+				zap.String("connection", c.Request.URL.Path),
+				zap.Error(err))
+		}
+	}
+}
+
+// idempotencyCaller is the default Scope, a digest of the caller's token.
+func idempotencyCaller(c *gin.Context) string {
+	token := c.GetString("token")
+	if token == "" {
+		token = c.GetHeader("Authorization")
+	}
+	if token == "" {
+		return ""
+	}
+	return tokenKey(token)
+}
+
+func abortIdempotency(c *gin.Context, status int, code, description string) {
+	c.AbortWithStatusJSON(status, HTTPResponse{
+		Status:      false,
+		ErrorCode:   code,
+		Description: description,
+	})
+}
+
+// bodyTooLarge reports the error of http.MaxBytesReader, which has no type
+// before Go 1.19.
+func bodyTooLarge(err error) bool {
+	return err.Error() == "http: request body too large"
+}
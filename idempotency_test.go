@@ -0,0 +1,197 @@
+package library
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := NewCacheFixture(t)
+
+	var orders int32
+	release := make(chan struct{})
+	r := gin.New()
+	r.Use(IdempotencyMiddleware(f.RedisCache, IdempotencyConfig{ResponseTTL: time.Hour}, zap.NewNop()))
+	r.POST("/orders", func(c *gin.Context) {
+		if c.Query("wait") != "" {
+			<-release
+		}
+		n := atomic.AddInt32(&orders, 1)
+		c.Header("X-Order", "created")
+		GoodResponse(c, map[string]int32{"order": n})
+	})
+	r.POST("/fail", func(c *gin.Context) {
+		BadResponse(zap.NewNop(), c, RespParams{ErrorCode: "INVALID", Description: "bad input", Error: errors.New("bad")})
+	})
+	r.POST("/crash", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/orders", "k1", `{"item":1}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(IdempotencyReplayedHeader))
+
+	again := send("/orders", "k1", `{"item":1}`)
+	assert.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String())
+	assert.Equal(t, "created", again.Header().Get("X-Order"))
+	assert.Equal(t, "true", again.Header().Get(IdempotencyReplayedHeader))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&orders))
+	f.AssertTTL("idempotency:k1", time.Hour)
+
+	w := send("/orders", "k1", `{"item":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "IDEMPOTENCY_KEY_REUSED")
+	w = send("/orders?item=2", "k1", `{"item":1}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// BadResponse is stored and replayed too
+	bad := send("/fail", "k2", "")
+	assert.Equal(t, http.StatusBadRequest, bad.Code)
+	w = send("/fail", "k2", "")
+	assert.Equal(t, bad.Body.String(), w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(IdempotencyReplayedHeader))
+
+	// server errors are not stored
+	send("/crash", "k3", "")
+	f.AssertKeyMissing("idempotency:k3")
+
+	// no key, no idempotency
+	send("/orders", "", "")
+	send("/orders", "", "")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&orders))
+
+	// a duplicate of a running request is rejected
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send("/orders?wait=1", "k4", "") }()
+	assert.Eventually(t, func() bool { return f.MR.Exists(f.key("idempotency:k4:lock")) }, time.Second, 5*time.Millisecond)
+	w = send("/orders?wait=1", "k4", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "IDEMPOTENCY_IN_PROGRESS")
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-done).Code)
+	f.AssertKeyMissing("idempotency:k4:lock")
+}
+
+func TestIdempotencyMiddlewareRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(IdempotencyMiddleware(MockCache(t), IdempotencyConfig{Required: true}, zap.NewNop()))
+	r.POST("/", func(c *gin.Context) { GoodResponse(c, "ok") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "IDEMPOTENCY_KEY_REQUIRED")
+}
+
+func TestIdempotencyMiddlewareBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls int32
+	r := gin.New()
+	r.Use(IdempotencyMiddleware(MockCache(t), IdempotencyConfig{MaxBodyBytes: 8}, zap.NewNop()))
+	r.POST("/", func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		GoodResponse(c, "ok")
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, body)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, send("12345678").Code)
+	w := send("123456789")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "BODY_TOO_LARGE")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyMiddlewareCallers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := NewCacheFixture(t)
+	var orders int32
+	r := gin.New()
+	r.Use(IdempotencyMiddleware(f.RedisCache, IdempotencyConfig{}, zap.NewNop()))
+	r.POST("/orders", func(c *gin.Context) {
+		GoodResponse(c, atomic.AddInt32(&orders, 1))
+	})
+
+	send := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"item":1}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		req.Header.Set("Authorization", token)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// the same key and body from another caller is a request of its own
+	alice := send("Bearer alice")
+	bob := send("Bearer bob")
+	assert.Equal(t, http.StatusOK, bob.Code)
+	assert.Empty(t, bob.Header().Get(IdempotencyReplayedHeader))
+	assert.NotEqual(t, alice.Body.String(), bob.Body.String())
+	assert.Equal(t, alice.Body.String(), send("Bearer alice").Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&orders))
+
+	// tokens never show in key names
+	for _, k := range f.MR.Keys() {
+		assert.NotContains(t, k, "alice")
+	}
+}
+
+func TestIdempotencyMiddlewareCacheDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := NewCacheFixture(t)
+	var calls int32
+	handler := func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		GoodResponse(c, "ok")
+	}
+	closed, open := gin.New(), gin.New()
+	closed.Use(IdempotencyMiddleware(f.RedisCache, IdempotencyConfig{}, zap.NewNop()))
+	closed.POST("/", handler)
+	open.Use(IdempotencyMiddleware(f.RedisCache, IdempotencyConfig{FailOpen: true}, zap.NewNop()))
+	open.POST("/", handler)
+
+	send := func(r *gin.Engine) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	f.MR.SetError("LOADING")
+	w := send(closed)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "IDEMPOTENCY_UNAVAILABLE")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, http.StatusOK, send(open).Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
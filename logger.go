@@ -34,6 +34,16 @@ func Logging(LOG *zap.Logger, rp RespParams) {
 }
 
 func NewLogger(path string, debug bool) (*zap.Logger, error) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	if debug {
+		level.SetLevel(zap.DebugLevel)
+	}
+	return NewLoggerWithLevel(path, debug, level)
+}
+
+// NewLoggerWithLevel is NewLogger with a level that can be changed at runtime,
+// for example from a ConfigWatcher subscription.
+func NewLoggerWithLevel(path string, debug bool, level zap.AtomicLevel) (*zap.Logger, error) {
 	w := zapcore.AddSync(&lumberjack.Logger{
 		Filename:   path + time.Now().Format("20060102") + ".log",
 		MaxSize:    100, // megabytes
@@ -51,11 +61,6 @@ func NewLogger(path string, debug bool) (*zap.Logger, error) {
 
 	consoleEncoder := zapcore.NewConsoleEncoder(pe)
 
-	level := zap.InfoLevel
-	if debug {
-		level = zap.DebugLevel
-	}
-
 	core := zapcore.NewTee(
 		zapcore.NewCore(fileEncoder, zapcore.AddSync(w), level),
 		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), level),
@@ -0,0 +1,524 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+var ErrDuplicateJob = errors.New("duplicate job")
+
+// jobFetchTimeout is how long a worker blocks on an empty queue; Redis does
+// not accept less than a second.
+const jobFetchTimeout = time.Second
+
+// requeueJobsScript hands the jobs a dead worker was running back to the
+// ready list and forgets the worker.
+var requeueJobsScript = redis.NewScript(`
+local n = 0
+while redis.call("RPOPLPUSH", KEYS[1], KEYS[2]) do
+	n = n + 1
+end
+redis.call("ZREM", KEYS[3], ARGV[1])
+return n
+`)
+
+var promoteJobsScript = redis.NewScript(`
+local jobs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call("ZREM", KEYS[1], job)
+	redis.call("LPUSH", KEYS[2], job)
+end
+return #jobs
+`)
+
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	MaxRetries int             `json:"max_retries"`
+	UniqueKey  string          `json:"unique_key,omitempty"`
+	LastError  string          `json:"last_error,omitempty"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+}
+
+// Decode unmarshals the job payload into v.
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+type JobHandler func(ctx context.Context, job Job) error
+
+type jobOptions struct {
+	runAt      time.Time
+	maxRetries int
+	uniqueKey  string
+	uniqueTTL  time.Duration
+}
+
+type JobOption func(*jobOptions)
+
+// JobDelay runs the job no earlier than d from now.
+func JobDelay(d time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = time.Now().Add(d)
+	}
+}
+
+// JobAt runs the job no earlier than t.
+func JobAt(t time.Time) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = t
+	}
+}
+
+func JobMaxRetries(n int) JobOption {
+	return func(o *jobOptions) {
+		o.maxRetries = n
+	}
+}
+
+// JobUnique rejects the job with ErrDuplicateJob while another job with the
+// same key is queued or running, for at most ttl.
+func JobUnique(key string, ttl time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.uniqueKey = key
+		o.uniqueTTL = ttl
+	}
+}
+
+type periodicJob struct {
+	interval time.Duration
+	jobType  string
+	payload  interface{}
+}
+
+// Queue is a Redis-backed job queue. Ready jobs sit in a list, delayed and
+// retried jobs in a sorted set scored by run time, and jobs out of retries in
+// a dead-letter list. A worker moves the job it fetches to its own processing
+// list and removes it only once the job is done, so the jobs of a worker
+// that died are handed to another one: delivery is at least once.
+type Queue struct {
+	Name         string
+	Concurrency  int
+	PollInterval time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	MaxRetries   int
+	// WorkerTimeout is how long a worker may miss its heartbeat, sent every
+	// PollInterval, before its jobs are requeued.
+	WorkerTimeout time.Duration
+
+	id       string
+	cache    *RedisCache
+	log      *zap.Logger
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	periodic []periodicJob
+}
+
+func NewQueue(cache *RedisCache, name string, log *zap.Logger) *Queue {
+	return &Queue{
+		Name:          name,
+		Concurrency:   1,
+		PollInterval:  time.Second,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    10 * time.Minute,
+		MaxRetries:    5,
+		WorkerTimeout: time.Minute,
+		id:            UUID(),
+		cache:         cache,
+		log:           log,
+		handlers:      map[string]JobHandler{},
+	}
+}
+
+func (q *Queue) key(part string) string {
+	return q.cache.key("queue:" + q.Name + ":" + part)
+}
+
+// Handle registers the handler for jobType.
+func (q *Queue) Handle(jobType string, h JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// Every enqueues jobType once per interval across all replicas running Run.
+// Time is cut into interval-long slots and a lock per slot decides which
+// replica enqueues it.
+func (q *Queue) Every(interval time.Duration, jobType string, payload interface{}) error {
+	if interval <= 0 {
+		return fmt.Errorf("every %s : interval must be positive", jobType)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.periodic = append(q.periodic, periodicJob{interval: interval, jobType: jobType, payload: payload})
+	return nil
+}
+
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...JobOption) (Job, error) {
+	handleErr := func(err error) (Job, error) {
+		return Job{}, fmt.Errorf("enqueue %s : %w", jobType, err)
+	}
+
+	o := jobOptions{maxRetries: q.MaxRetries}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data := ToBytes(payload)
+	if data == nil {
+		return handleErr(errors.New("payload is not JSON serializable"))
+	}
+	job := Job{
+		ID:         UUID(),
+		Type:       jobType,
+		Payload:    data,
+		MaxRetries: o.maxRetries,
+		UniqueKey:  o.uniqueKey,
+		EnqueuedAt: time.Now(),
+	}
+
+	if job.UniqueKey != "" {
+		ok, err := q.cache.rdb.SetNX(ctx, q.key("unique:"+job.UniqueKey), job.ID, o.uniqueTTL).Result()
+		if err != nil {
+			return handleErr(err)
+		}
+		if !ok {
+			return handleErr(ErrDuplicateJob)
+		}
+	}
+
+	if err := q.push(ctx, job, o.runAt); err != nil {
+		return handleErr(err)
+	}
+	return job, nil
+}
+
+func (q *Queue) push(ctx context.Context, job Job, runAt time.Time) error {
+	data := string(ToBytes(job))
+	if runAt.After(time.Now()) {
+		return q.cache.rdb.ZAdd(ctx, q.key("scheduled"), &redis.Z{
+			Score:  float64(runAt.UnixMilli()),
+			Member: data,
+		}).Err()
+	}
+	return q.cache.rdb.LPush(ctx, q.key("ready"), data).Err()
+}
+
+// DeadJobs lists the jobs that ran out of retries, newest first.
+func (q *Queue) DeadJobs(ctx context.Context) ([]Job, error) {
+	items, err := q.cache.rdb.LRange(ctx, q.key("dead"), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("dead jobs : %w", err)
+	}
+	jobs := make([]Job, 0, len(items))
+	for _, item := range items {
+		var job Job
+		if err := json.Unmarshal([]byte(item), &job); err != nil {
+			return nil, fmt.Errorf("dead jobs : %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Run processes jobs with Concurrency workers until ctx is done, then waits
+// for running jobs to finish.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.promote(ctx)
+	}()
+
+	q.mu.RLock()
+	for _, p := range q.periodic {
+		wg.Add(1)
+		go func(p periodicJob) {
+			defer wg.Done()
+			q.schedule(ctx, p)
+		}(p)
+	}
+	q.mu.RUnlock()
+
+	workers := make([]string, q.Concurrency)
+	for i := range workers {
+		workers[i] = fmt.Sprintf("%s:%d", q.id, i)
+	}
+	q.beat(workers)
+
+	// heartbeats go on until the jobs running at shutdown are done, so no
+	// replica takes them over meanwhile
+	stopBeat := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.monitor(ctx, stopBeat, workers)
+	}()
+
+	var workersWg sync.WaitGroup
+	for _, worker := range workers {
+		workersWg.Add(1)
+		go func(worker string) {
+			defer workersWg.Done()
+			q.work(ctx, worker)
+		}(worker)
+	}
+
+	q.log.Info("queue started", zap.String("queue", q.Name), zap.Int("concurrency", q.Concurrency))
+	workersWg.Wait()
+	close(stopBeat)
+	wg.Wait()
+	// a fetch cut short by the shutdown may still have moved a job
+	for _, worker := range workers {
+		if err := q.requeue(ctxB, worker); err != nil {
+			q.log.Error("requeue jobs of stopped worker", zap.String("queue", q.Name), zap.String("worker", worker), zap.Error(err))
+		}
+	}
+	q.log.Info("queue stopped", zap.String("queue", q.Name))
+}
+
+// monitor refreshes the heartbeat of this replica's workers until stop is
+// closed and, while ctx is alive, requeues the jobs of workers on any replica
+// whose heartbeat is older than WorkerTimeout.
+func (q *Queue) monitor(ctx context.Context, stop <-chan struct{}, workers []string) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		q.beat(workers)
+		if ctx.Err() != nil {
+			continue
+		}
+		if err := q.reap(ctx); err != nil && ctx.Err() == nil {
+			q.log.Error("requeue jobs of dead workers", zap.String("queue", q.Name), zap.Error(err))
+		}
+	}
+}
+
+func (q *Queue) beat(workers []string) {
+	now := float64(time.Now().UnixMilli())
+	members := make([]*redis.Z, len(workers))
+	for i, w := range workers {
+		members[i] = &redis.Z{Score: now, Member: w}
+	}
+	if err := q.cache.rdb.ZAdd(ctxB, q.key("workers"), members...).Err(); err != nil {
+		q.log.Error("queue worker heartbeat", zap.String("queue", q.Name), zap.Error(err))
+	}
+}
+
+// reap requeues the jobs of the workers that stopped beating.
+func (q *Queue) reap(ctx context.Context) error {
+	deadline := time.Now().Add(-q.WorkerTimeout).UnixMilli()
+	dead, err := q.cache.rdb.ZRangeByScore(ctx, q.key("workers"), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(deadline, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, worker := range dead {
+		if err := q.requeue(ctx, worker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue moves the jobs in the processing list of worker back to the ready
+// list and drops the worker from the heartbeat set.
+func (q *Queue) requeue(ctx context.Context, worker string) error {
+	n, err := requeueJobsScript.Run(ctx, q.cache.rdb,
+		[]string{q.key("processing:" + worker), q.key("ready"), q.key("workers")}, worker).Int()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		q.log.Warn("requeued jobs of worker",
+			zap.String("queue", q.Name),
+			zap.String("worker", worker),
+			zap.Int("jobs", n))
+	}
+	return nil
+}
+
+// promote moves scheduled jobs whose time has come to the ready list.
+func (q *Queue) promote(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := promoteJobsScript.Run(ctx, q.cache.rdb,
+			[]string{q.key("scheduled"), q.key("ready")}, time.Now().UnixMilli(), 100).Result()
+		if err != nil && ctx.Err() == nil {
+			q.log.Error("promote scheduled jobs", zap.String("queue", q.Name), zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) schedule(ctx context.Context, p periodicJob) {
+	for {
+		// ticks fall on slot boundaries, the same ones on every replica
+		slot := time.Now().Truncate(p.interval).Add(p.interval)
+		timer := time.NewTimer(time.Until(slot))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := q.enqueuePeriodic(ctx, p, slot); err != nil && ctx.Err() == nil {
+			q.log.Error("enqueue periodic job", zap.String("queue", q.Name), zap.String("type", p.jobType), zap.Error(err))
+		}
+	}
+}
+
+// enqueuePeriodic enqueues p for the interval starting at slot unless another
+// replica already did. The slot lock is never released, it expires once the
+// slot is over.
+func (q *Queue) enqueuePeriodic(ctx context.Context, p periodicJob, slot time.Time) (bool, error) {
+	name := fmt.Sprintf("queue:%s:periodic:%s:%d", q.Name, p.jobType, slot.UnixMilli())
+	_, err := q.cache.Lock(ctx, name, 2*p.interval)
+	if errors.Is(err, ErrLockNotObtained) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := q.Enqueue(ctx, p.jobType, p.payload); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (q *Queue) work(ctx context.Context, worker string) {
+	processing := q.key("processing:" + worker)
+	for ctx.Err() == nil {
+		// the job stays in the processing list until it is acknowledged
+		raw, err := q.cache.rdb.BRPopLPush(ctx, q.key("ready"), processing, jobFetchTimeout).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			q.log.Error("fetch job", zap.String("queue", q.Name), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.PollInterval):
+			}
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal([]byte(raw), &job); err != nil {
+			q.log.Error("decode job", zap.String("queue", q.Name), zap.String("job", raw), zap.Error(err))
+			q.ack(processing, raw)
+			continue
+		}
+		q.process(job)
+		q.ack(processing, raw)
+	}
+}
+
+// ack removes a handled job from the worker's processing list.
+func (q *Queue) ack(processing, raw string) {
+	if err := q.cache.rdb.LRem(ctxB, processing, 1, raw).Err(); err != nil {
+		q.log.Error("ack job", zap.String("queue", q.Name), zap.Error(err))
+	}
+}
+
+func (q *Queue) process(job Job) {
+	// jobs run to completion even while the queue shuts down
+	ctx := ctxB
+	job.Attempts++
+	err := q.call(ctx, job)
+	if err == nil {
+		q.finish(ctx, job)
+		return
+	}
+
+	job.LastError = err.Error()
+	if job.Attempts > job.MaxRetries {
+		q.log.Error("job failed, moved to dead letter",
+			zap.String("queue", q.Name),
+			zap.String("type", job.Type),
+			zap.String("id", job.ID),
+			zap.Int("attempts", job.Attempts),
+			zap.Error(err))
+		if err := q.cache.rdb.LPush(ctx, q.key("dead"), string(ToBytes(job))).Err(); err != nil {
+			q.log.Error("store dead job", zap.String("queue", q.Name), zap.String("id", job.ID), zap.Error(err))
+		}
+		q.finish(ctx, job)
+		return
+	}
+
+	backoff := q.backoff(job.Attempts)
+	q.log.Warn("job failed, retrying",
+		zap.String("queue", q.Name),
+		zap.String("type", job.Type),
+		zap.String("id", job.ID),
+		zap.Int("attempts", job.Attempts),
+		zap.Duration("backoff", backoff),
+		zap.Error(err))
+	if err := q.push(ctx, job, time.Now().Add(backoff)); err != nil {
+		q.log.Error("reschedule job", zap.String("queue", q.Name), zap.String("id", job.ID), zap.Error(err))
+	}
+}
+
+func (q *Queue) call(ctx context.Context, job Job) (err error) {
+	q.mu.RLock()
+	h, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (q *Queue) finish(ctx context.Context, job Job) {
+	// the key may have expired and been taken by a newer job meanwhile
+	if job.UniqueKey != "" {
+		releaseScript.Run(ctx, q.cache.rdb, []string{q.key("unique:" + job.UniqueKey)}, job.ID)
+	}
+}
+
+// backoff doubles BaseBackoff per attempt up to MaxBackoff, with up to 20%
+// jitter so retries of a failing batch spread out.
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.BaseBackoff << uint(attempt-1)
+	if d <= 0 || d > q.MaxBackoff {
+		d = q.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5 + 1))
+	return d - d/10 + jitter
+}
@@ -0,0 +1,207 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type emailJob struct {
+	To string `json:"to"`
+}
+
+func TestQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := NewQueue(MockCache(t), "mail", zap.NewNop())
+	q.Concurrency = 2
+	q.PollInterval = 20 * time.Millisecond
+	q.BaseBackoff = 10 * time.Millisecond
+	q.MaxRetries = 1
+
+	var mu sync.Mutex
+	sent := map[string]int{}
+	q.Handle("send", func(ctx context.Context, job Job) error {
+		var e emailJob
+		if err := job.Decode(&e); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sent[e.To]++
+		if e.To == "bounce@example.com" {
+			return errors.New("mailbox unavailable")
+		}
+		return nil
+	})
+
+	_, err := q.Enqueue(ctx, "send", emailJob{To: "a@example.com"}, JobUnique("welcome:a", time.Minute))
+	assert.NoError(t, err)
+	_, err = q.Enqueue(ctx, "send", emailJob{To: "a@example.com"}, JobUnique("welcome:a", time.Minute))
+	assert.ErrorIs(t, err, ErrDuplicateJob)
+	_, err = q.Enqueue(ctx, "send", emailJob{To: "later@example.com"}, JobDelay(100*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = q.Enqueue(ctx, "send", emailJob{To: "bounce@example.com"})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		dead, err := q.DeadJobs(context.Background())
+		mu.Lock()
+		defer mu.Unlock()
+		return err == nil && len(dead) == 1 && sent["later@example.com"] == 1
+	}, 2*time.Second, 20*time.Millisecond)
+
+	dead, _ := q.DeadJobs(context.Background())
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Equal(t, "mailbox unavailable", dead[0].LastError)
+	assert.Equal(t, 1, sent["a@example.com"])
+
+	// the unique key is released once the job is done
+	_, err = q.Enqueue(context.Background(), "send", emailJob{To: "a@example.com"}, JobUnique("welcome:a", time.Minute))
+	assert.NoError(t, err)
+
+	cancel()
+	<-done
+}
+
+func TestQueueRequeuesJobsOfDeadWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := NewCacheFixture(t)
+	q := NewQueue(f.RedisCache, "mail", zap.NewNop())
+	q.PollInterval = 10 * time.Millisecond
+	q.WorkerTimeout = 200 * time.Millisecond
+
+	handled := make(chan string, 10)
+	q.Handle("send", func(ctx context.Context, job Job) error {
+		var e emailJob
+		assert.NoError(t, job.Decode(&e))
+		handled <- e.To
+		return nil
+	})
+
+	// a worker of another replica fetched a job and died
+	job := Job{ID: "j1", Type: "send", Payload: ToBytes(emailJob{To: "lost@example.com"})}
+	assert.NoError(t, f.rdb.LPush(ctx, q.key("processing:gone:0"), string(ToBytes(job))).Err())
+	stale := float64(time.Now().Add(-time.Minute).UnixMilli())
+	assert.NoError(t, f.rdb.ZAdd(ctx, q.key("workers"), &redis.Z{Score: stale, Member: "gone:0"}).Err())
+
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case to := <-handled:
+		assert.Equal(t, "lost@example.com", to)
+	case <-time.After(2 * time.Second):
+		t.Fatal("job of the dead worker was not requeued")
+	}
+	f.AssertKeyMissing("queue:mail:processing:gone:0")
+
+	// a live worker keeps its jobs; a handled job leaves its processing list
+	_, err := q.Enqueue(ctx, "send", emailJob{To: "b@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "b@example.com", <-handled)
+	assert.Eventually(t, func() bool {
+		keys, _ := f.rdb.Keys(ctx, q.key("processing:*")).Result()
+		return len(keys) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	n, err := f.rdb.ZCard(context.Background(), q.key("workers")).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestQueueShutdownKeepsJobs(t *testing.T) {
+	f := NewCacheFixture(t)
+	q := NewQueue(f.RedisCache, "mail", zap.NewNop())
+	q.Concurrency = 4
+	var handled int32
+	q.Handle("noop", func(ctx context.Context, job Job) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	for i := 0; i < 2000; i++ {
+		_, err := q.Enqueue(context.Background(), "noop", i)
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	q.Run(ctx)
+
+	// every job was either handled or is back in the ready list
+	keys, err := f.rdb.Keys(context.Background(), q.key("processing:*")).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	ready, err := f.rdb.LLen(context.Background(), q.key("ready")).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), int64(atomic.LoadInt32(&handled))+ready)
+}
+
+func TestQueuePeriodic(t *testing.T) {
+	ctx := context.Background()
+	c := MockCache(t)
+	a, b := NewQueue(c, "cron", zap.NewNop()), NewQueue(c, "cron", zap.NewNop())
+	p := periodicJob{interval: time.Minute, jobType: "report"}
+
+	// both schedulers fire for every slot, only one of them enqueues it
+	start := time.Now().Truncate(time.Minute)
+	for i := 0; i < 3; i++ {
+		slot := start.Add(time.Duration(i) * time.Minute)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		enqueued := 0
+		for _, q := range []*Queue{a, b} {
+			wg.Add(1)
+			go func(q *Queue) {
+				defer wg.Done()
+				ok, err := q.enqueuePeriodic(ctx, p, slot)
+				assert.NoError(t, err)
+				if ok {
+					mu.Lock()
+					enqueued++
+					mu.Unlock()
+				}
+			}(q)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, enqueued, "slot %d", i)
+	}
+
+	n, err := c.rdb.LLen(ctx, a.key("ready")).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	assert.Error(t, a.Every(0, "report", nil))
+	assert.NoError(t, a.Every(time.Minute, "report", nil))
+}
+
+func TestQueueFinishKeepsNewerUniqueKey(t *testing.T) {
+	ctx := context.Background()
+	f := NewCacheFixture(t)
+	q := NewQueue(f.RedisCache, "mail", zap.NewNop())
+
+	// the unique key of an old job expired and a new job took it
+	assert.NoError(t, f.rdb.Set(ctx, q.key("unique:k"), "new", time.Minute).Err())
+	q.finish(ctx, Job{ID: "old", UniqueKey: "k"})
+	assert.Equal(t, "new", f.rdb.Get(ctx, q.key("unique:k")).Val())
+
+	q.finish(ctx, Job{ID: "new", UniqueKey: "k"})
+	f.AssertKeyMissing("queue:mail:unique:k")
+}
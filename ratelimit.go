@@ -0,0 +1,255 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// RateLimitResult is the outcome of one RateLimiter.Allow call.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+var fixedWindowScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {n, redis.call("PTTL", KEYS[1])}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, count + 1, 0}
+end
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return {0, count, tonumber(oldest[2]) + window - now}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + (now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return {allowed, math.floor(tokens), wait}
+`)
+
+type fixedWindowLimiter struct {
+	cache  *RedisCache
+	limit  int
+	window time.Duration
+}
+
+// NewFixedWindowLimiter allows limit requests per key in each window.
+func NewFixedWindowLimiter(cache *RedisCache, limit int, window time.Duration) (RateLimiter, error) {
+	if err := checkWindow("fixed window", limit, window); err != nil {
+		return nil, err
+	}
+	return &fixedWindowLimiter{cache: cache, limit: limit, window: window}, nil
+}
+
+// checkWindow rejects the limits the window scripts cannot enforce: Redis
+// takes the window in whole milliseconds and deletes a key given a zero TTL.
+func checkWindow(name string, limit int, window time.Duration) error {
+	if limit <= 0 {
+		return fmt.Errorf("%s : limit must be positive, got %d", name, limit)
+	}
+	if window < time.Millisecond {
+		return fmt.Errorf("%s : window must be at least 1ms, got %s", name, window)
+	}
+	return nil
+}
+
+func (l *fixedWindowLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	res, err := fixedWindowScript.Run(ctx, l.cache.rdb, []string{l.cache.key("ratelimit:fw:" + key)},
+		l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit %s : %w", key, err)
+	}
+
+	count, reset := int(res[0]), time.Duration(res[1])*time.Millisecond
+	result := RateLimitResult{
+		Allowed:    count <= l.limit,
+		Limit:      l.limit,
+		Remaining:  maxInt(l.limit-count, 0),
+		ResetAfter: reset,
+	}
+	if !result.Allowed {
+		result.RetryAfter = reset
+	}
+	return result, nil
+}
+
+type slidingWindowLimiter struct {
+	cache  *RedisCache
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindowLimiter allows limit requests per key in any window-long
+// span, keeping a log of request times in a sorted set.
+func NewSlidingWindowLimiter(cache *RedisCache, limit int, window time.Duration) (RateLimiter, error) {
+	if err := checkWindow("sliding window", limit, window); err != nil {
+		return nil, err
+	}
+	return &slidingWindowLimiter{cache: cache, limit: limit, window: window}, nil
+}
+
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	res, err := slidingWindowScript.Run(ctx, l.cache.rdb, []string{l.cache.key("ratelimit:sw:" + key)},
+		now, l.window.Milliseconds(), l.limit, UUID()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit %s : %w", key, err)
+	}
+
+	result := RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      l.limit,
+		Remaining:  maxInt(l.limit-int(res[1]), 0),
+		ResetAfter: l.window,
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration(res[2]) * time.Millisecond
+		result.ResetAfter = result.RetryAfter
+	}
+	return result, nil
+}
+
+type tokenBucketLimiter struct {
+	cache *RedisCache
+	rate  float64
+	burst int
+}
+
+// NewTokenBucketLimiter refills rate tokens per second up to burst; each
+// request takes one token.
+func NewTokenBucketLimiter(cache *RedisCache, rate float64, burst int) (RateLimiter, error) {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return nil, fmt.Errorf("token bucket : rate must be positive, got %v", rate)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("token bucket : burst must be positive, got %d", burst)
+	}
+	return &tokenBucketLimiter{cache: cache, rate: rate, burst: burst}, nil
+}
+
+func (l *tokenBucketLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	perMs := l.rate / 1000
+	fullIn := time.Duration(float64(l.burst)/l.rate*float64(time.Second)) + time.Second
+	res, err := tokenBucketScript.Run(ctx, l.cache.rdb, []string{l.cache.key("ratelimit:tb:" + key)},
+		time.Now().UnixMilli(), strconv.FormatFloat(perMs, 'f', -1, 64), l.burst, fullIn.Milliseconds()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit %s : %w", key, err)
+	}
+
+	remaining := int(res[1])
+	result := RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      l.burst,
+		Remaining:  remaining,
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		ResetAfter: time.Duration(float64(l.burst-remaining) / l.rate * float64(time.Second)),
+	}
+	return result, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// RateLimitKeyFunc picks the bucket a request is counted against.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitByToken uses a digest of the token GoodResponse echoes back, so
+// the token never shows in key names, falling back to the client IP for
+// anonymous requests.
+func RateLimitByToken(c *gin.Context) string {
+	if token := c.GetString("token"); token != "" {
+		return "token:" + tokenKey(token)
+	}
+	return RateLimitByIP(c)
+}
+
+// RateLimitMiddleware rejects requests over the limit with 429 and the
+// HTTPResponse envelope. Limiter errors are logged and the request is let
+// through.
+func RateLimitMiddleware(limiter RateLimiter, keyFunc RateLimitKeyFunc, log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := limiter.Allow(c.Request.Context(), keyFunc(c))
+		if err != nil {
+			log.Warn("rate limiter",
+				zap.String("connection", c.Request.URL.Path),
+				zap.Error(err))
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, HTTPResponse{
+				Status:      false,
+				ErrorCode:   "RATE_LIMITED",
+				Description: "too many requests",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
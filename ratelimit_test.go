@@ -0,0 +1,103 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRateLimiters(t *testing.T) {
+	ctx := context.Background()
+	c := MockCache(t)
+	bucket, err := NewTokenBucketLimiter(c, 0.1, 3)
+	assert.NoError(t, err)
+	fixed, err := NewFixedWindowLimiter(c, 3, time.Minute)
+	assert.NoError(t, err)
+	sliding, err := NewSlidingWindowLimiter(c, 3, time.Minute)
+	assert.NoError(t, err)
+	limiters := map[string]RateLimiter{
+		"fixed":   fixed,
+		"sliding": sliding,
+		"bucket":  bucket,
+	}
+	for name, l := range limiters {
+		for i := 0; i < 3; i++ {
+			res, err := l.Allow(ctx, "user")
+			assert.NoError(t, err, name)
+			assert.True(t, res.Allowed, name)
+			assert.Equal(t, 2-i, res.Remaining, name)
+		}
+		res, err := l.Allow(ctx, "user")
+		assert.NoError(t, err, name)
+		assert.False(t, res.Allowed, name)
+		assert.Greater(t, res.RetryAfter, time.Duration(0), name)
+
+		res, err = l.Allow(ctx, "other")
+		assert.NoError(t, err, name)
+		assert.True(t, res.Allowed, name)
+	}
+}
+
+func TestTokenBucketLimiterInvalid(t *testing.T) {
+	c := MockCache(t)
+	for _, tc := range []struct {
+		rate  float64
+		burst int
+	}{{0, 3}, {-1, 3}, {math.NaN(), 3}, {1, 0}, {1, -1}} {
+		_, err := NewTokenBucketLimiter(c, tc.rate, tc.burst)
+		assert.Error(t, err, "rate %v burst %d", tc.rate, tc.burst)
+	}
+}
+
+func TestWindowLimitersInvalid(t *testing.T) {
+	c := MockCache(t)
+	for _, tc := range []struct {
+		limit  int
+		window time.Duration
+	}{{0, time.Minute}, {-1, time.Minute}, {3, 0}, {3, time.Microsecond}} {
+		_, err := NewFixedWindowLimiter(c, tc.limit, tc.window)
+		assert.Error(t, err, "limit %d window %s", tc.limit, tc.window)
+		_, err = NewSlidingWindowLimiter(c, tc.limit, tc.window)
+		assert.Error(t, err, "limit %d window %s", tc.limit, tc.window)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := NewCacheFixture(t)
+	limiter, err := NewFixedWindowLimiter(f.RedisCache, 1, time.Minute)
+	assert.NoError(t, err)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("token", "abc") })
+	r.Use(RateLimitMiddleware(limiter, RateLimitByToken, zap.NewNop()))
+	r.GET("/", func(c *gin.Context) { GoodResponse(c, "ok") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	var resp HTTPResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "RATE_LIMITED", resp.ErrorCode)
+
+	// the token itself never shows in key names
+	keys := f.MR.Keys()
+	assert.NotEmpty(t, keys)
+	for _, k := range keys {
+		assert.NotContains(t, k, "abc")
+	}
+}
@@ -0,0 +1,50 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const redacted = "***"
+
+// Secret holds a credential. It prints, logs and marshals as "***"; use
+// Reveal to get the actual value. zap.Any and zap.Stringer log it through
+// String, nested in a struct it goes through MarshalJSON.
+//
+// The Password fields of DBParam, DBConfiguration and CacheConfiguration
+// used to be plain strings: assign them with Secret(v) and read them with
+// Reveal.
+type Secret string
+
+var (
+	_ fmt.Stringer   = Secret("")
+	_ json.Marshaler = Secret("")
+)
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// SecretFromFile reads a secret mounted as a file, such as a Docker or
+// Kubernetes secret. A trailing newline is dropped.
+func SecretFromFile(path string) (Secret, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file : %w", err)
+	}
+	return Secret(strings.TrimRight(string(raw), "\r\n")), nil
+}
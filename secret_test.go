@@ -0,0 +1,67 @@
+package library
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSecretRedaction(t *testing.T) {
+	cfg := DBConfiguration{Host: "localhost", Password: "hunter2"}
+
+	assert.NotContains(t, ToJSONString(cfg), "hunter2")
+	assert.Contains(t, ToJSONString(cfg), `"Password":"***"`)
+
+	core, logs := observer.New(zap.InfoLevel)
+	zap.New(core).Info("config", zap.Any("password", cfg.Password), zap.Any("db", cfg))
+	for _, entry := range logs.All() {
+		for _, v := range entry.ContextMap() {
+			assert.NotContains(t, ToJSONString(v), "hunter2")
+		}
+	}
+
+	dsn := formatMySQLString(DBParam{User: "root", Password: cfg.Password}, cfg.Password.String())
+	assert.False(t, strings.Contains(dsn, "hunter2"))
+	assert.Contains(t, makeMySQLString(DBParam{User: "root", Password: cfg.Password}), "hunter2")
+}
+
+func TestSecretZapJSONEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	log := zap.New(zapcore.NewCore(enc, zapcore.AddSync(&buf), zap.DebugLevel))
+
+	secret := Secret("hunter2")
+	cfg := DBConfiguration{Host: "localhost", Password: secret}
+	log.Info("config",
+		zap.Any("any", secret),
+		zap.Stringer("stringer", secret),
+		zap.Reflect("reflect", secret),
+		zap.Any("db", cfg),
+		zap.Any("param", DBParam{Password: secret}))
+
+	out := buf.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, `"any":"***"`)
+	assert.Contains(t, out, `"stringer":"***"`)
+	assert.Contains(t, out, `"reflect":"***"`)
+	assert.Contains(t, out, `"Password":"***"`)
+}
+
+func TestSecretFromFileEnv(t *testing.T) {
+	path := t.TempDir() + "/db_password"
+	assert.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_NAME", "orders")
+	t.Setenv("DB_USERNAME", "admin")
+	t.Setenv("DB_PASSWORD_FILE", path)
+
+	var cfg DBConfiguration
+	assert.NoError(t, LoadEnv(&cfg))
+	assert.Equal(t, "from-file", cfg.Password.Reveal())
+}
@@ -0,0 +1,246 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+const sessionContextKey = "session"
+
+type Session struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Device    string            `json:"device"`
+	IP        string            `json:"ip"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// SessionStore keeps sessions in the cache with a sliding TTL and indexes
+// them per user in a set, so a user's sessions can be listed and revoked.
+type SessionStore struct {
+	// MaxSessions caps the active sessions per user; when exceeded the
+	// oldest ones are revoked. Zero means no limit.
+	MaxSessions int
+	TTL         time.Duration
+
+	cache    *RedisCache
+	sessions TypedCache[Session]
+}
+
+// NewSessionStore keeps sessions for ttl after their last use. The per-user
+// index expires with the sessions, so ttl must be positive.
+func NewSessionStore(cache *RedisCache, ttl time.Duration, maxSessions int) (*SessionStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("session store : ttl must be positive, got %s", ttl)
+	}
+	return &SessionStore{
+		MaxSessions: maxSessions,
+		TTL:         ttl,
+		cache:       cache,
+		sessions:    NewTypedCache[Session](cache, JSONCodec),
+	}, nil
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+func (s *SessionStore) userKey(userID string) string {
+	return s.cache.key("session:user:" + userID)
+}
+
+// Create starts a new session for userID, revoking the oldest ones when the
+// user is over MaxSessions.
+func (s *SessionStore) Create(ctx context.Context, userID, device, ip string, metadata map[string]string) (Session, error) {
+	handleErr := func(err error) (Session, error) {
+		return Session{}, fmt.Errorf("create session : %w", err)
+	}
+
+	now := time.Now()
+	sess := Session{
+		ID:        UUID(),
+		UserID:    userID,
+		Device:    device,
+		IP:        ip,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.TTL),
+	}
+	if err := s.save(ctx, sess); err != nil {
+		return handleErr(err)
+	}
+
+	if s.MaxSessions > 0 {
+		active, err := s.List(ctx, userID)
+		if err != nil {
+			return handleErr(err)
+		}
+		for i := 0; i < len(active)-s.MaxSessions; i++ {
+			if err := s.Revoke(ctx, active[i].ID); err != nil {
+				return handleErr(err)
+			}
+		}
+	}
+
+	return sess, nil
+}
+
+func (s *SessionStore) save(ctx context.Context, sess Session) error {
+	if err := s.sessions.SetCtx(ctx, sessionKey(sess.ID), sess, s.TTL); err != nil {
+		return err
+	}
+	pipe := s.cache.rdb.TxPipeline()
+	pipe.SAdd(ctx, s.userKey(sess.UserID), sess.ID)
+	pipe.Expire(ctx, s.userKey(sess.UserID), s.TTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Get returns ErrSessionNotFound for unknown or expired sessions.
+func (s *SessionStore) Get(ctx context.Context, id string) (Session, error) {
+	sess, err := s.sessions.GetCtx(ctx, sessionKey(id))
+	if errors.Is(err, ErrCacheMiss) {
+		return Session{}, ErrSessionNotFound
+	}
+	return sess, err
+}
+
+// Refresh pushes the session's expiry TTL into the future.
+func (s *SessionStore) Refresh(ctx context.Context, id string) (Session, error) {
+	sess, err := s.Get(ctx, id)
+	if err != nil {
+		return Session{}, err
+	}
+	sess.ExpiresAt = time.Now().Add(s.TTL)
+	if err := s.save(ctx, sess); err != nil {
+		return Session{}, fmt.Errorf("refresh session : %w", err)
+	}
+	return sess, nil
+}
+
+// List returns the user's active sessions, oldest first, and drops expired
+// ids from the index.
+func (s *SessionStore) List(ctx context.Context, userID string) ([]Session, error) {
+	ids, err := s.cache.rdb.SMembers(ctx, s.userKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list sessions : %w", err)
+	}
+
+	var active []Session
+	var stale []interface{}
+	for _, id := range ids {
+		sess, err := s.Get(ctx, id)
+		if errors.Is(err, ErrSessionNotFound) {
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list sessions : %w", err)
+		}
+		active = append(active, sess)
+	}
+	if len(stale) > 0 {
+		s.cache.rdb.SRem(ctx, s.userKey(userID), stale...)
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].CreatedAt.Before(active[j].CreatedAt)
+	})
+	return active, nil
+}
+
+func (s *SessionStore) Revoke(ctx context.Context, id string) error {
+	sess, err := s.Get(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("revoke session : %w", err)
+	}
+
+	pipe := s.cache.rdb.TxPipeline()
+	pipe.Del(ctx, s.cache.key(sessionKey(id)))
+	pipe.SRem(ctx, s.userKey(sess.UserID), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke session : %w", err)
+	}
+	return nil
+}
+
+// RevokeAll ends every session of userID, for example after a password change.
+func (s *SessionStore) RevokeAll(ctx context.Context, userID string) error {
+	ids, err := s.cache.rdb.SMembers(ctx, s.userKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("revoke sessions : %w", err)
+	}
+
+	pipe := s.cache.rdb.Pipeline()
+	pipe.Del(ctx, s.userKey(userID))
+	for _, id := range ids {
+		pipe.Del(ctx, s.cache.key(sessionKey(id)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke sessions : %w", err)
+	}
+	return nil
+}
+
+// SessionMiddleware loads and refreshes the session named by the
+// Authorization header, storing it in the context along with its id as
+// "token". Requests without a valid session get 401.
+func SessionMiddleware(store *SessionStore, log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if id == "" {
+			abortUnauthorized(c, "missing session token")
+			return
+		}
+
+		sess, err := store.Refresh(c.Request.Context(), id)
+		if errors.Is(err, ErrSessionNotFound) {
+			abortUnauthorized(c, "invalid or expired session")
+			return
+		}
+		if err != nil {
+			log.Error("load session",
+				zap.String("connection", c.Request.URL.Path),
+				zap.Error(err))
+			abortUnauthorized(c, "session unavailable")
+			return
+		}
+
+		c.Set(sessionContextKey, sess)
+		c.Set("token", sess.ID)
+		c.Next()
+	}
+}
+
+func abortUnauthorized(c *gin.Context, description string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, HTTPResponse{
+		Status:      false,
+		ErrorCode:   "UNAUTHORIZED",
+		Description: description,
+	})
+}
+
+// SessionFromContext returns the session SessionMiddleware stored.
+func SessionFromContext(c *gin.Context) (Session, bool) {
+	v, ok := c.Get(sessionContextKey)
+	if !ok {
+		return Session{}, false
+	}
+	sess, ok := v.(Session)
+	return sess, ok
+}
@@ -0,0 +1,76 @@
+package library
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSessionStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSessionStore(MockCache(t), time.Hour, 2)
+	assert.NoError(t, err)
+
+	first, err := store.Create(ctx, "u1", "phone", "10.0.0.1", nil)
+	assert.NoError(t, err)
+	second, err := store.Create(ctx, "u1", "laptop", "10.0.0.2", map[string]string{"os": "linux"})
+	assert.NoError(t, err)
+	third, err := store.Create(ctx, "u1", "tablet", "10.0.0.3", nil)
+	assert.NoError(t, err)
+
+	sessions, err := store.List(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, second.ID, sessions[0].ID)
+	assert.Equal(t, third.ID, sessions[1].ID)
+
+	_, err = store.Get(ctx, first.ID)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	assert.NoError(t, store.Revoke(ctx, second.ID))
+	sessions, err = store.List(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	assert.NoError(t, store.RevokeAll(ctx, "u1"))
+	sessions, err = store.List(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// a zero TTL would expire the user index at once
+	_, err = NewSessionStore(MockCache(t), 0, 2)
+	assert.Error(t, err)
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewSessionStore(MockCache(t), time.Hour, 0)
+	assert.NoError(t, err)
+	sess, err := store.Create(context.Background(), "u1", "phone", "10.0.0.1", nil)
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.Use(SessionMiddleware(store, zap.NewNop()))
+	r.GET("/me", func(c *gin.Context) {
+		s, ok := SessionFromContext(c)
+		assert.True(t, ok)
+		GoodResponse(c, s.UserID)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+sess.ID)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), sess.ID)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
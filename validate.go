@@ -0,0 +1,165 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ConfigValidator is implemented by configurations with rules spanning
+// several fields. Returning FieldErrors keeps the field-level detail.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ValidateStruct checks the `validate` tags of v and then calls Validate on v
+// and every nested struct implementing ConfigValidator. Supported rules are
+// required, numeric, min=N, max=N and oneof=a b c. All failures are returned
+// together as FieldErrors.
+func ValidateStruct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validate : expected struct, got %T", v)
+	}
+
+	errs := validateStruct(rv, "")
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, path string) FieldErrors {
+	var errs FieldErrors
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fv := rv.Field(i)
+		fieldPath := sf.Name
+		if path != "" {
+			fieldPath = path + "." + sf.Name
+		}
+		key, _ := parseEnvTag(sf.Tag.Get("env"))
+
+		if rules := sf.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				if err := checkRule(fv, strings.TrimSpace(rule)); err != nil {
+					errs = append(errs, FieldError{Field: fieldPath, Key: key, Err: err})
+					break
+				}
+			}
+		}
+
+		nested := reflect.Indirect(fv)
+		if nested.Kind() == reflect.Struct && allExported(nested.Type()) {
+			errs = append(errs, validateStruct(nested, fieldPath)...)
+		}
+	}
+
+	target := rv.Interface()
+	if rv.CanAddr() {
+		target = rv.Addr().Interface()
+	}
+	if cv, ok := target.(ConfigValidator); ok {
+		errs = append(errs, prefixFieldErrors(cv.Validate(), path)...)
+	}
+
+	return errs
+}
+
+func prefixFieldErrors(err error, path string) FieldErrors {
+	if err == nil {
+		return nil
+	}
+
+	var fe FieldErrors
+	if !errors.As(err, &fe) {
+		return FieldErrors{{Field: path, Err: err}}
+	}
+	if path == "" {
+		return fe
+	}
+
+	out := make(FieldErrors, len(fe))
+	for i, e := range fe {
+		e.Field = path + "." + e.Field
+		out[i] = e
+	}
+	return out
+}
+
+func checkRule(fv reflect.Value, rule string) error {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	switch name {
+	case "required":
+		if fv.IsZero() {
+			return errors.New("is required")
+		}
+	case "numeric":
+		if fv.Kind() == reflect.String && fv.String() != "" {
+			if _, err := strconv.ParseFloat(fv.String(), 64); err != nil {
+				return fmt.Errorf("must be numeric, got %q", fv.String())
+			}
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule %q", rule)
+		}
+		n, ok := ruleNumber(fv)
+		if !ok {
+			return nil
+		}
+		if name == "min" && n < limit {
+			return fmt.Errorf("must be at least %s, got %v", arg, n)
+		}
+		if name == "max" && n > limit {
+			return fmt.Errorf("must be at most %s, got %v", arg, n)
+		}
+	case "oneof":
+		val := fmt.Sprint(fv.Interface())
+		for _, opt := range strings.Fields(arg) {
+			if val == opt {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of [%s], got %q", arg, val)
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+
+	return nil
+}
+
+// ruleNumber gives the value min/max compare against: the number itself, a
+// numeric string parsed, or the length of anything else.
+func ruleNumber(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), true
+	case reflect.String:
+		if fv.String() == "" {
+			return 0, false
+		}
+		if n, err := strconv.ParseFloat(fv.String(), 64); err == nil {
+			return n, true
+		}
+		return float64(len(fv.String())), true
+	case reflect.Slice, reflect.Map:
+		return float64(fv.Len()), true
+	}
+	return 0, false
+}
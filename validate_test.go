@@ -0,0 +1,56 @@
+package library
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestValidateDBConfiguration(t *testing.T) {
+	cfg := DBConfiguration{
+		Port:        "54x2",
+		DBName:      "orders",
+		Username:    "admin",
+		MaxOpenConn: 5,
+		MaxIdleConn: 10,
+	}
+
+	err := ValidateStruct(cfg)
+	var fe FieldErrors
+	assert.True(t, errors.As(err, &fe))
+	assert.Len(t, fe, 3)
+	assert.Equal(t, "Host", fe[0].Field)
+	assert.Equal(t, "Port", fe[1].Field)
+	assert.Equal(t, "DB_PORT", fe[1].Key)
+	assert.Equal(t, "MaxIdleConn", fe[2].Field)
+
+	cfg.Host = "localhost"
+	cfg.Port = "5432"
+	cfg.MaxIdleConn = 5
+	assert.NoError(t, ValidateStruct(cfg))
+}
+
+func TestValidateNested(t *testing.T) {
+	var cfg struct {
+		Mode  string `validate:"oneof=dev prod"`
+		Cache CacheConfiguration
+	}
+	cfg.Mode = "test"
+
+	var fe FieldErrors
+	assert.True(t, errors.As(ValidateStruct(&cfg), &fe))
+	assert.Len(t, fe, 3)
+	assert.Equal(t, "Cache.URL", fe[1].Field)
+}
+
+func TestConstructorsValidate(t *testing.T) {
+	_, err := NewPostgresConnection(DBConfiguration{Port: "5432"}, zap.NewNop())
+	var fe FieldErrors
+	assert.True(t, errors.As(err, &fe))
+
+	_, err = NewCache(CacheConfiguration{Port: "redis"}, 0)
+	assert.True(t, errors.As(err, &fe))
+	assert.Len(t, fe, 2)
+}